@@ -0,0 +1,559 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The JSON API lives under apiPrefix and is described by the OpenAPI spec
+// in static/openapi.json; keep the two in step.
+const apiPrefix = "/api/v1"
+
+const (
+	apiDefaultPageSize = 50
+	apiMaxPageSize     = 200
+)
+
+type apiFeed struct {
+	ID                  int        `json:"id"`
+	Title               string     `json:"title"`
+	URL                 string     `json:"url"`
+	Folder              string     `json:"folder"`
+	SubscribedAt        time.Time  `json:"subscribed_at"`
+	NextCheckAt         *time.Time `json:"next_check_at,omitempty"`
+	LastSuccessAt       *time.Time `json:"last_success_at,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	Disabled            bool       `json:"disabled"`
+}
+
+type apiArticle struct {
+	ID             int         `json:"id"`
+	Title          string      `json:"title"`
+	Summary        string      `json:"summary"` // HTML, sanitized when the article was stored
+	URL            string      `json:"url"`
+	FeedID         int         `json:"feed_id"`
+	Feed           string      `json:"feed"`
+	PublishedAt    time.Time   `json:"published_at"`
+	FetchedAt      time.Time   `json:"fetched_at"`
+	Category       string      `json:"category"`
+	Sentiment      string      `json:"sentiment"`
+	SentimentScore float64     `json:"sentiment_score"`
+	Bias           string      `json:"bias"`
+	ImageURL       string      `json:"image_url,omitempty"`
+	StoryID        int         `json:"story_id,omitempty"`
+	Read           bool        `json:"read"`
+	Starred        bool        `json:"starred"`
+	Snippet        string      `json:"snippet,omitempty"`
+	Content        *apiContent `json:"content,omitempty"`
+	Entities       []apiEntity `json:"entities,omitempty"`
+}
+
+// apiContent is the full text extracted from the article page, included
+// only when a single article is requested. Its HTML is sanitized like the
+// summary.
+type apiContent struct {
+	HTML        string     `json:"html"`
+	Method      string     `json:"method,omitempty"`
+	Status      string     `json:"status,omitempty"`
+	ExtractedAt *time.Time `json:"extracted_at,omitempty"`
+}
+
+type apiEntity struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type apiArticlePage struct {
+	Articles []apiArticle `json:"articles"`
+	Page     int          `json:"page"`
+	PerPage  int          `json:"per_page"`
+	Total    int          `json:"total"`
+}
+
+// apiRetention is the dry-run retention report of one feed, ages in
+// seconds with 0 for no limit.
+type apiRetention struct {
+	FeedID       int                 `json:"feed_id"`
+	Feed         string              `json:"feed"`
+	IngestMaxAge int64               `json:"ingest_max_age"`
+	MaxAge       int64               `json:"max_age"`
+	MaxItems     int                 `json:"max_items"`
+	Stored       int                 `json:"stored"`
+	Starred      int                 `json:"starred"`
+	Expired      []apiExpiredArticle `json:"expired"`
+}
+
+type apiExpiredArticle struct {
+	ID          int       `json:"id"`
+	Title       string    `json:"title"`
+	PublishedAt time.Time `json:"published_at"`
+	Reason      string    `json:"reason"`
+}
+
+func apiTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func newAPIFeed(f Feed) apiFeed {
+	return apiFeed{
+		ID:                  f.ID,
+		Title:               f.Name,
+		URL:                 f.URL,
+		Folder:              f.Folder,
+		SubscribedAt:        f.CreatedAt,
+		NextCheckAt:         apiTime(f.NextCheckAt),
+		LastSuccessAt:       apiTime(f.LastSuccessAt),
+		LastError:           f.LastError,
+		ConsecutiveFailures: f.ConsecutiveFailures,
+		Disabled:            f.Disabled,
+	}
+}
+
+func newAPIArticle(a Article) apiArticle {
+	return apiArticle{
+		ID:             a.ID,
+		Title:          a.Title,
+		Summary:        a.Summary,
+		URL:            a.URL,
+		FeedID:         a.FeedID,
+		Feed:           a.FeedName,
+		PublishedAt:    a.PublishedAt,
+		FetchedAt:      a.CreatedAt,
+		Category:       a.Category,
+		Sentiment:      a.Sentiment,
+		SentimentScore: a.SentimentScore,
+		Bias:           a.Bias,
+		ImageURL:       a.ImageURL,
+		StoryID:        a.StoryID,
+		Read:           a.Read,
+		Starred:        a.Starred,
+		Snippet:        string(a.Snippet),
+	}
+}
+
+func newAPIArticlePage(articles []Article, page, perPage, total int) apiArticlePage {
+	result := apiArticlePage{Articles: []apiArticle{}, Page: page, PerPage: perPage, Total: total}
+	for _, a := range articles {
+		result.Articles = append(result.Articles, newAPIArticle(a))
+	}
+	return result
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error writing API response: %v", err)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// decodeJSON reads a request body of at most 1 MB into v, rejecting
+// unknown fields so that typos do not pass silently.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("invalid JSON body: %v", err)
+	}
+	return nil
+}
+
+// apiID parses the numeric ID following prefix in the request path.
+func apiID(r *http.Request, prefix string) (int, bool) {
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, prefix))
+	return id, err == nil && id > 0
+}
+
+// apiPagination reads the page and per_page parameters.
+func apiPagination(params url.Values) (page, perPage int, err error) {
+	page, perPage = 1, apiDefaultPageSize
+	if v := params.Get("page"); v != "" {
+		if page, err = strconv.Atoi(v); err != nil || page < 1 {
+			return 0, 0, errors.New("page must be a positive number")
+		}
+	}
+	if v := params.Get("per_page"); v != "" {
+		if perPage, err = strconv.Atoi(v); err != nil || perPage < 1 || perPage > apiMaxPageSize {
+			return 0, 0, fmt.Errorf("per_page must be between 1 and %d", apiMaxPageSize)
+		}
+	}
+	return page, perPage, nil
+}
+
+// apiArticleFilter reads the article filters: the search page filters plus
+// bias, unread and starred. Unlike the pages, an invalid filter is an error
+// rather than ignored.
+func apiArticleFilter(params url.Values) (ArticleFilter, error) {
+	var filter ArticleFilter
+	for _, key := range []string{"feed", "category", "sentiment", "after", "before", "has"} {
+		if v := params.Get(key); v != "" && !applySearchFilter(&filter, key, v) {
+			return filter, fmt.Errorf("invalid %s %q", key, v)
+		}
+	}
+	if bias := params.Get("bias"); bias != "" {
+		if bias != biasUnrated && !containsString(biasLabels, bias) {
+			return filter, fmt.Errorf("invalid bias %q", bias)
+		}
+		filter.Bias = bias
+	}
+	for key, field := range map[string]*bool{"unread": &filter.UnreadOnly, "starred": &filter.StarredOnly} {
+		if v := params.Get(key); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s %q", key, v)
+			}
+			*field = b
+		}
+	}
+	return filter, nil
+}
+
+// findFeed returns the user's subscription to the feed.
+func findFeed(userID, feedID int) (Feed, error) {
+	feeds, err := getFeeds(db, userID)
+	if err != nil {
+		return Feed{}, err
+	}
+	for _, f := range feeds {
+		if f.ID == feedID {
+			return f, nil
+		}
+	}
+	return Feed{}, sql.ErrNoRows
+}
+
+// apiSpecHandler serves the OpenAPI description of the API.
+func apiSpecHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	http.ServeFile(w, r, "static/openapi.json")
+}
+
+// apiFeedsHandler lists the user's feeds or subscribes to a new one.
+func apiFeedsHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	switch r.Method {
+	case http.MethodGet:
+		feeds, err := getFeeds(db, user.ID)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "failed to load feeds")
+			return
+		}
+		result := []apiFeed{}
+		for _, f := range feeds {
+			result = append(result, newAPIFeed(f))
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"feeds": result})
+	case http.MethodPost:
+		if !requireScope(w, r, scopeFeeds) {
+			return
+		}
+		var req struct {
+			URL    string `json:"url"`
+			Title  string `json:"title"`
+			Folder string `json:"folder"`
+		}
+		if err := decodeJSON(w, r, &req); err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if strings.TrimSpace(req.URL) == "" {
+			writeAPIError(w, http.StatusBadRequest, "url is required")
+			return
+		}
+		feed, status, err := apiSubscribe(user, strings.TrimSpace(req.URL), strings.TrimSpace(req.Title), strings.TrimSpace(req.Folder))
+		if err != nil {
+			writeAPIError(w, status, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusCreated, newAPIFeed(feed))
+	default:
+		writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// apiSubscribe subscribes the user to the feed found at pageURL, like the
+// add and subscribe forms together, and returns the new subscription or an
+// HTTP status and error.
+func apiSubscribe(user User, pageURL, title, folder string) (Feed, int, error) {
+	candidates, err := discoverFeeds(pageURL)
+	if err != nil {
+		return Feed{}, http.StatusBadGateway, fmt.Errorf("could not load %s: %v", pageURL, err)
+	}
+	switch len(candidates) {
+	case 0:
+		return Feed{}, http.StatusUnprocessableEntity, fmt.Errorf("no RSS, Atom or JSON feed found at %s", pageURL)
+	case 1:
+	default:
+		urls := make([]string, len(candidates))
+		for i, c := range candidates {
+			urls[i] = c.URL
+		}
+		return Feed{}, http.StatusUnprocessableEntity, fmt.Errorf("%s offers several feeds, subscribe to one of: %s", pageURL, strings.Join(urls, ", "))
+	}
+	preview, err := previewFeed(candidates[0].URL)
+	if err != nil {
+		return Feed{}, http.StatusUnprocessableEntity, fmt.Errorf("invalid feed: %v", err)
+	}
+	if title == "" {
+		title = preview.Title
+	}
+	if title == "" {
+		title = feedHost(preview.URL)
+	}
+	feedID, err := addFeed(db, user.ID, title, preview.URL, folder)
+	if err != nil {
+		if err == errAlreadySubscribed {
+			return Feed{}, http.StatusConflict, err
+		}
+		return Feed{}, http.StatusInternalServerError, fmt.Errorf("failed to add feed: %v", err)
+	}
+	refreshFeedInBackground(db, feedID)
+	feed, err := findFeed(user.ID, feedID)
+	if err != nil {
+		return Feed{}, http.StatusInternalServerError, fmt.Errorf("failed to load feed: %v", err)
+	}
+	return feed, 0, nil
+}
+
+// apiFeedHandler shows, renames or moves, or unsubscribes from one feed.
+func apiFeedHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	feedID, ok := apiID(r, apiPrefix+"/feeds/")
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "feed not found")
+		return
+	}
+	feed, err := findFeed(user.ID, feedID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeAPIError(w, http.StatusNotFound, "feed not found")
+			return
+		}
+		writeAPIError(w, http.StatusInternalServerError, "failed to load feed")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, newAPIFeed(feed))
+	case http.MethodPatch:
+		if !requireScope(w, r, scopeFeeds) {
+			return
+		}
+		var req struct {
+			Title  *string `json:"title"`
+			Folder *string `json:"folder"`
+		}
+		if err := decodeJSON(w, r, &req); err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		title, folder := feed.Name, feed.Folder
+		if req.Title != nil {
+			title = strings.TrimSpace(*req.Title)
+		}
+		if req.Folder != nil {
+			folder = strings.TrimSpace(*req.Folder)
+		}
+		if err := updateSubscription(db, user.ID, strconv.Itoa(feedID), title, folder); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "failed to update feed")
+			return
+		}
+		if feed, err = findFeed(user.ID, feedID); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "failed to load feed")
+			return
+		}
+		writeJSON(w, http.StatusOK, newAPIFeed(feed))
+	case http.MethodDelete:
+		if !requireScope(w, r, scopeFeeds) {
+			return
+		}
+		if err := unsubscribeFeed(db, user.ID, strconv.Itoa(feedID)); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "failed to delete feed")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// apiArticlesHandler lists the user's articles newest first, filtered and
+// paginated.
+func apiArticlesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	params := r.URL.Query()
+	page, perPage, err := apiPagination(params)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter, err := apiArticleFilter(params)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	articles, total, err := listArticles(db, currentUser(r).ID, filter, perPage, (page-1)*perPage)
+	if err != nil {
+		log.Printf("[ERROR] Error listing articles: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, "failed to load articles")
+		return
+	}
+	writeJSON(w, http.StatusOK, newAPIArticlePage(articles, page, perPage, total))
+}
+
+// apiArticleHandler returns one article with its extracted content, or
+// changes its read and starred state.
+func apiArticleHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	id, ok := apiID(r, apiPrefix+"/articles/")
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "article not found")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPatch:
+		if !requireScope(w, r, scopeFeeds) {
+			return
+		}
+		var req struct {
+			Read    *bool `json:"read"`
+			Starred *bool `json:"starred"`
+		}
+		if err := decodeJSON(w, r, &req); err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		var err error
+		if req.Read != nil {
+			err = setArticleRead(db, user.ID, id, *req.Read)
+		}
+		if err == nil && req.Starred != nil {
+			err = setArticleStarred(db, user.ID, id, *req.Starred)
+		}
+		if err != nil {
+			if err == sql.ErrNoRows {
+				writeAPIError(w, http.StatusNotFound, "article not found")
+				return
+			}
+			writeAPIError(w, http.StatusInternalServerError, "failed to update article")
+			return
+		}
+	default:
+		writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	article, err := getArticleByID(db, user.ID, strconv.Itoa(id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeAPIError(w, http.StatusNotFound, "article not found")
+			return
+		}
+		writeAPIError(w, http.StatusInternalServerError, "failed to load article")
+		return
+	}
+	result := newAPIArticle(article)
+	result.Content = &apiContent{
+		HTML:        article.Content,
+		Method:      article.ContentMethod,
+		Status:      article.ContentStatus,
+		ExtractedAt: apiTime(article.ContentExtractedAt),
+	}
+	entities, err := getArticleEntities(db, article.ID)
+	if err != nil {
+		log.Printf("[ERROR] Error getting article entities: %v", err)
+	}
+	for _, e := range entities {
+		result.Entities = append(result.Entities, apiEntity{Name: e.Name, Type: e.Type})
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// apiSearchHandler runs a search like the search page, taking the same
+// parameters plus sort and pagination.
+func apiSearchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	params := r.URL.Query()
+	page, perPage, err := apiPagination(params)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	order := searchByRelevance
+	switch params.Get("sort") {
+	case "", searchByRelevance:
+	case searchByDate:
+		order = searchByDate
+	default:
+		writeAPIError(w, http.StatusBadRequest, "sort must be relevance or date")
+		return
+	}
+	text, filter := searchFromParams(params)
+	if text == "" && filter == (ArticleFilter{}) {
+		writeAPIError(w, http.StatusBadRequest, "q or a filter is required")
+		return
+	}
+	articles, total, err := searchArticles(db, currentUser(r).ID, text, filter, order, perPage, (page-1)*perPage)
+	if err != nil {
+		log.Printf("[ERROR] Error searching articles: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, "failed to search articles")
+		return
+	}
+	writeJSON(w, http.StatusOK, newAPIArticlePage(articles, page, perPage, total))
+}
+
+// apiRetentionHandler reports what the next cleanup would delete, like the
+// admin Retention page.
+func apiRetentionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	reports, err := planRetention(db, time.Now())
+	if err != nil {
+		log.Printf("[ERROR] Error planning cleanup: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, "failed to plan cleanup")
+		return
+	}
+	result := []apiRetention{}
+	for _, report := range reports {
+		feed := apiRetention{
+			FeedID:       report.Feed.ID,
+			Feed:         report.Feed.Name,
+			IngestMaxAge: int64(report.Policy.IngestMaxAge / time.Second),
+			MaxAge:       int64(report.Policy.MaxAge / time.Second),
+			MaxItems:     report.Policy.MaxItems,
+			Stored:       report.Stored,
+			Starred:      report.Starred,
+			Expired:      []apiExpiredArticle{},
+		}
+		for _, a := range report.Expired {
+			feed.Expired = append(feed.Expired, apiExpiredArticle{ID: a.ID, Title: a.Title, PublishedAt: a.PublishedAt, Reason: a.Reason})
+		}
+		result = append(result, feed)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"feeds": result})
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// biasLabels is the rating scale, from left to right. Articles from
+// sources without a rating are labelled biasUnrated.
+var biasLabels = []string{"left", "lean-left", "center", "lean-right", "right"}
+
+const biasUnrated = "unrated"
+
+// biasAliases maps spellings found in published rating lists onto the scale.
+var biasAliases = map[string]string{
+	"-2": "left", "-1": "lean-left", "0": "center", "1": "lean-right", "2": "right",
+	"centre": "center", "least-biased": "center",
+	"center-left": "lean-left", "left-center": "lean-left", "left-leaning": "lean-left",
+	"center-right": "lean-right", "right-center": "lean-right", "right-leaning": "lean-right",
+}
+
+// BiasRating is an admin-assigned rating for every feed and article served
+// from a domain and its subdomains.
+type BiasRating struct {
+	Domain    string
+	Rating    string
+	UpdatedAt time.Time
+}
+
+// BiasImportResult reports what happened to one CSV row on import.
+type BiasImportResult struct {
+	Line    int
+	Domain  string
+	Rating  string
+	Status  string // "saved" or "error"
+	Message string
+}
+
+// BalanceColumn holds the articles on a topic from sources with one rating.
+type BalanceColumn struct {
+	Bias     string
+	Articles []Article
+}
+
+// normalizeBias maps a rating as typed by an admin or found in a CSV onto
+// biasLabels. It reports false for anything it does not recognise.
+func normalizeBias(s string) (string, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	s = strings.NewReplacer(" ", "-", "_", "-").Replace(s)
+	if alias, ok := biasAliases[s]; ok {
+		s = alias
+	}
+	return s, containsString(biasLabels, s)
+}
+
+// ratingDomain reduces a domain or URL to the host ratings are keyed by.
+func ratingDomain(raw string) string {
+	raw = strings.TrimSpace(raw)
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	return strings.TrimPrefix(host, "www.")
+}
+
+// lookupDomainRating finds the rating for host, falling back to its parent
+// domains so that a rating for example.com also covers news.example.com.
+func lookupDomainRating(ratings map[string]string, host string) (string, bool) {
+	host = ratingDomain(host)
+	for host != "" {
+		if rating, ok := ratings[host]; ok {
+			return rating, true
+		}
+		i := strings.Index(host, ".")
+		if i < 0 {
+			break
+		}
+		host = host[i+1:]
+	}
+	return "", false
+}
+
+// resolveArticleBias picks the rating for an article: the feed's own rating
+// wins, then the domain of the article link, then the domain of the feed.
+func resolveArticleBias(ratings map[string]string, feed Feed, articleURL string) string {
+	if feed.Bias != "" {
+		return feed.Bias
+	}
+	if rating, ok := lookupDomainRating(ratings, articleURL); ok {
+		return rating
+	}
+	if rating, ok := lookupDomainRating(ratings, feed.URL); ok {
+		return rating
+	}
+	return biasUnrated
+}
+
+func getBiasRatings(db *sql.DB) ([]BiasRating, error) {
+	rows, err := db.Query("SELECT domain, rating, updated_at FROM bias_ratings ORDER BY domain")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ratings []BiasRating
+	for rows.Next() {
+		var r BiasRating
+		if err := rows.Scan(&r.Domain, &r.Rating, &r.UpdatedAt); err != nil {
+			return nil, err
+		}
+		ratings = append(ratings, r)
+	}
+	return ratings, rows.Err()
+}
+
+func getBiasRatingMap(db *sql.DB) (map[string]string, error) {
+	ratings, err := getBiasRatings(db)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]string, len(ratings))
+	for _, r := range ratings {
+		m[r.Domain] = r.Rating
+	}
+	return m, nil
+}
+
+func saveBiasRating(db *sql.DB, domain, rating string) error {
+	_, err := db.Exec(`
+		INSERT INTO bias_ratings (domain, rating, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT(domain) DO UPDATE SET rating = excluded.rating, updated_at = excluded.updated_at
+	`, domain, rating, time.Now().UTC())
+	return err
+}
+
+func deleteBiasRating(db *sql.DB, domain string) error {
+	_, err := db.Exec("DELETE FROM bias_ratings WHERE domain = ?", domain)
+	return err
+}
+
+func setFeedBias(db *sql.DB, feedID int, rating string) error {
+	_, err := db.Exec("UPDATE feeds SET bias = ? WHERE id = ?", rating, feedID)
+	return err
+}
+
+// getRatedFeeds returns every feed with its own rating, for the admin page.
+func getRatedFeeds(db *sql.DB) ([]Feed, error) {
+	rows, err := db.Query("SELECT id, name, url, IFNULL(bias, '') FROM feeds ORDER BY name COLLATE NOCASE")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var feeds []Feed
+	for rows.Next() {
+		var f Feed
+		if err := rows.Scan(&f.ID, &f.Name, &f.URL, &f.Bias); err != nil {
+			return nil, err
+		}
+		feeds = append(feeds, f)
+	}
+	return feeds, rows.Err()
+}
+
+// importBiasCSV saves a rating for every "domain,rating" row. A header row
+// and lines starting with # are skipped; bad rows are reported and do not
+// stop the import.
+func importBiasCSV(db *sql.DB, r io.Reader) ([]BiasImportResult, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+
+	var results []BiasImportResult
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			results = append(results, BiasImportResult{Line: parseErr.Line, Status: "error", Message: parseErr.Err.Error()})
+			continue
+		}
+		if err != nil {
+			return results, err
+		}
+		line, _ := reader.FieldPos(0)
+		if len(record) < 2 {
+			results = append(results, BiasImportResult{Line: line, Status: "error", Message: "expected domain,rating"})
+			continue
+		}
+		if line == 1 && strings.EqualFold(strings.TrimSpace(record[0]), "domain") {
+			continue
+		}
+		result := BiasImportResult{Line: line, Domain: ratingDomain(record[0]), Rating: record[1]}
+		rating, ok := normalizeBias(record[1])
+		switch {
+		case result.Domain == "":
+			result.Status, result.Message = "error", "invalid domain"
+		case !ok:
+			result.Status, result.Message = "error", fmt.Sprintf("unknown rating %q", record[1])
+		default:
+			result.Rating = rating
+			if err := saveBiasRating(db, result.Domain, rating); err != nil {
+				result.Status, result.Message = "error", err.Error()
+			} else {
+				result.Status = "saved"
+			}
+		}
+		results = append(results, result)
+	}
+	return results, reapplyBiasRatings(db)
+}
+
+// reapplyBiasRatings recomputes the bias of every stored article after the
+// ratings change, so existing articles match what new ones would get.
+func reapplyBiasRatings(db *sql.DB) error {
+	ratings, err := getBiasRatingMap(db)
+	if err != nil {
+		return err
+	}
+	feeds, err := getRatedFeeds(db)
+	if err != nil {
+		return err
+	}
+	feedsByID := make(map[int]Feed, len(feeds))
+	for _, f := range feeds {
+		feedsByID[f.ID] = f
+	}
+
+	rows, err := db.Query("SELECT id, feed_id, url, IFNULL(bias, '') FROM articles")
+	if err != nil {
+		return err
+	}
+	changed := make(map[int]string)
+	for rows.Next() {
+		var id, feedID int
+		var link, current string
+		if err := rows.Scan(&id, &feedID, &link, &current); err != nil {
+			rows.Close()
+			return err
+		}
+		if bias := resolveArticleBias(ratings, feedsByID[feedID], link); bias != current {
+			changed[id] = bias
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for id, bias := range changed {
+		if _, err := tx.Exec("UPDATE articles SET bias = ? WHERE id = ?", bias, id); err != nil {
+			return err
+		}
+	}
+	if len(changed) > 0 {
+		log.Printf("Updated bias of %d articles", len(changed))
+	}
+	return tx.Commit()
+}
+
+func loadSourcesPage() (PageData, error) {
+	ratings, err := getBiasRatings(db)
+	if err != nil {
+		return PageData{}, err
+	}
+	feeds, err := getRatedFeeds(db)
+	if err != nil {
+		return PageData{}, err
+	}
+	return PageData{
+		Feeds:       feeds,
+		BiasRatings: ratings,
+		Active:      "sources",
+	}, nil
+}
+
+func adminSourcesHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := loadSourcesPage()
+	if err != nil {
+		http.Error(w, "Failed to load bias ratings: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	renderTemplate(w, r, "sources.html", data)
+}
+
+// saveBiasRatingHandler adds or updates a domain rating, or removes it
+// when the form asks for deletion.
+func saveBiasRatingHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	domain := ratingDomain(r.FormValue("domain"))
+	if domain == "" {
+		http.Error(w, "Domain is required", http.StatusBadRequest)
+		return
+	}
+	var err error
+	if r.FormValue("delete") != "" {
+		err = deleteBiasRating(db, domain)
+	} else {
+		rating, ok := normalizeBias(r.FormValue("rating"))
+		if !ok {
+			http.Error(w, "Unknown rating", http.StatusBadRequest)
+			return
+		}
+		err = saveBiasRating(db, domain, rating)
+	}
+	if err == nil {
+		err = reapplyBiasRatings(db)
+	}
+	if err != nil {
+		http.Error(w, "Failed to save rating: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/admin/sources", http.StatusSeeOther)
+}
+
+// feedBiasHandler sets or clears the rating of a single feed.
+func feedBiasHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var feedID int
+	if _, err := fmt.Sscanf(r.URL.Path[len("/admin/sources/feed/"):], "%d", &feedID); err != nil {
+		http.Error(w, "Invalid feed ID", http.StatusBadRequest)
+		return
+	}
+	rating := ""
+	if v := r.FormValue("rating"); v != "" {
+		var ok bool
+		if rating, ok = normalizeBias(v); !ok {
+			http.Error(w, "Unknown rating", http.StatusBadRequest)
+			return
+		}
+	}
+	if err := setFeedBias(db, feedID, rating); err != nil {
+		http.Error(w, "Failed to save rating: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := reapplyBiasRatings(db); err != nil {
+		http.Error(w, "Failed to update articles: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/admin/sources", http.StatusSeeOther)
+}
+
+func importBiasCSVHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 10<<20)
+	file, _, err := r.FormFile("csv")
+	if err != nil {
+		http.Error(w, "CSV file is required", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	results, err := importBiasCSV(db, file)
+	if err != nil {
+		http.Error(w, "Failed to import ratings: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("Bias rating import by %s: %d rows processed", currentUser(r).Username, len(results))
+
+	data, err := loadSourcesPage()
+	if err != nil {
+		http.Error(w, "Failed to load bias ratings: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data.BiasImportResults = results
+	renderTemplate(w, r, "sources.html", data)
+}
+
+// balanceHandler shows articles on one topic side by side, one column per
+// bias rating, so coverage from across the spectrum can be compared.
+func balanceHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	topic := strings.TrimSpace(r.URL.Query().Get("q"))
+	category := r.URL.Query().Get("category")
+
+	var articles []Article
+	var err error
+	if topic != "" {
+		text, filter := parseSearchQuery(topic)
+		articles, _, err = searchArticles(db, user.ID, text, filter, searchByRelevance, 100, 0)
+	} else {
+		articles, err = getFilteredArticles(db, user.ID, ArticleFilter{Category: category})
+	}
+	if err != nil {
+		log.Printf("[ERROR] Error getting articles: %v", err)
+		http.Error(w, "Failed to load articles", http.StatusInternalServerError)
+		return
+	}
+
+	const perColumn = 10
+	columns := make([]BalanceColumn, 0, len(biasLabels)+1)
+	labels := append(append([]string{}, biasLabels...), biasUnrated)
+	for _, label := range labels {
+		column := BalanceColumn{Bias: label}
+		for _, a := range articles {
+			if a.Bias == label && len(column.Articles) < perColumn {
+				column.Articles = append(column.Articles, a)
+			}
+		}
+		columns = append(columns, column)
+	}
+
+	renderTemplate(w, r, "balance.html", PageData{
+		Active:  "balance",
+		Query:   topic,
+		Filters: ArticleFilter{Category: category},
+		Balance: columns,
+	})
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Category is one entry of the category taxonomy. Articles store the slug.
+type Category struct {
+	ID       int
+	Name     string
+	Slug     string
+	Position int
+
+	// Keyword weights used by the NLP scorer.
+	Keywords map[string]float64
+	// Substrings of feed-supplied categories or feed names that map
+	// straight onto this category.
+	Aliases []string
+}
+
+// otherCategory is where articles go when nothing in the taxonomy fits. It
+// is not stored and cannot be edited.
+const otherCategory = "other"
+
+// defaultCategories seeds the taxonomy of a new database.
+var defaultCategories = []Category{
+	{
+		Name: "Politics", Slug: "politics",
+		Aliases: []string{"polit"},
+		Keywords: map[string]float64{
+			"politic": 1.0, "government": 0.9, "election": 0.9, "vote": 0.8, "democracy": 0.9,
+			"congress": 0.9, "senate": 0.9, "parliament": 0.9, "legislation": 0.9,
+			"president": 0.9, "minister": 0.9, "governor": 0.8, "democrat": 0.9, "republican": 0.9,
+			"policy": 0.8, "candidate": 0.8, "campaign": 0.8, "constitution": 0.9, "diplomatic": 0.9,
+			"law": 0.6, "party": 0.7, "administration": 0.8, "foreign affairs": 0.9, "domestic policy": 0.9,
+			"senator": 0.9, "congressman": 0.9, "ballot": 0.9, "lobbying": 0.9,
+			"bipartisan": 0.9, "filibuster": 0.9, "geopolitical": 0.9, "diplomat": 0.9,
+			"referendum": 0.9, "constituency": 0.9, "impeachment": 0.9, "veto": 0.9,
+		},
+	},
+	{
+		Name: "Technology", Slug: "technology",
+		Aliases: []string{"tech", "digital"},
+		Keywords: map[string]float64{
+			"tech": 1.0, "technology": 1.0, "software": 0.9, "hardware": 0.9, "programming": 0.8,
+			"developer": 0.8, "code": 0.7, "app": 0.5, "application": 0.6, "digital": 0.6, "ai": 0.9,
+			"artificial intelligence": 1.0, "machine learning": 0.9, "data": 0.5, "computer": 0.8,
+			"internet": 0.8, "cyber": 0.8, "algorithm": 0.8, "robot": 0.8, "automation": 0.8,
+		},
+	},
+	{
+		Name: "Business", Slug: "business",
+		Aliases: []string{"business", "econ"},
+		Keywords: map[string]float64{
+			"business": 1.0, "economy": 1.0, "market": 0.9, "finance": 0.9, "stock": 0.9,
+			"investment": 0.9, "company": 0.8, "industry": 0.9, "trade": 0.9, "commercial": 0.9,
+			"corporate": 0.9, "entrepreneur": 0.9, "startup": 0.9, "profit": 0.9, "revenue": 0.9,
+			"economic": 0.9, "financial": 0.9, "banking": 0.9, "investor": 0.9, "ceo": 0.8,
+		},
+	},
+	{
+		Name: "Sports", Slug: "sports",
+		Aliases: []string{"sport"},
+		Keywords: map[string]float64{
+			"sport": 1.0, "game": 0.8, "match": 0.9, "player": 0.9, "team": 0.9, "athlete": 0.9,
+			"championship": 0.9, "tournament": 0.9, "league": 0.9, "football": 1.0, "soccer": 1.0,
+			"basketball": 1.0, "baseball": 1.0, "tennis": 1.0, "cricket": 1.0, "hockey": 1.0,
+			"olympic": 1.0, "coach": 0.9, "score": 0.9, "win": 0.7, "loss": 0.7, "ipl": 1.0,
+			"goal": 0.8, "stadium": 0.9, "referee": 0.9, "umpire": 0.9, "nba": 1.0,
+			"nfl": 1.0, "mlb": 1.0, "fifa": 1.0, "nhl": 1.0, "pga": 1.0, "ufc": 1.0,
+			"medal": 0.8, "competition": 0.7, "trophy": 0.9, "shot": 0.6,
+			"fan": 0.7, "spectator": 0.8, "goalkeeper": 1.0, "runner": 0.9, "batter": 1.0,
+			"wicket": 1.0, "bowl": 0.7, "draft": 0.7, "rookie": 0.9, "playoff": 1.0,
+			"penalty": 0.7, "offside": 1.0, "batting": 1.0, "bowling": 0.9, "fielding": 0.9,
+			"defense": 0.6, "offense": 0.6, "quarter": 0.6, "inning": 1.0, "pitch": 0.7,
+			"grand slam": 1.0, "formula one": 1.0, "f1": 1.0, "boxing": 1.0, "racing": 0.8,
+			"marathon": 0.9, "touchdown": 1.0, "home run": 1.0, "slam dunk": 1.0, "free throw": 1.0,
+			"hat trick": 1.0, "athletics": 0.9, "gymnastics": 1.0, "swimming": 0.8,
+		},
+	},
+	{
+		Name: "Entertainment", Slug: "entertainment",
+		Aliases: []string{"entertain", "hollywood"},
+		Keywords: map[string]float64{
+			"entertain": 1.0, "movie": 1.0, "film": 1.0, "music": 1.0, "concert": 0.9,
+			"celebrity": 0.9, "actor": 0.9, "actress": 0.9, "director": 0.8, "tv": 0.9,
+			"television": 0.9, "show": 0.6, "drama": 0.9, "comedy": 0.9, "hollywood": 1.0,
+			"bollywood": 1.0, "star": 0.8, "singer": 0.9, "album": 0.9, "release": 0.7,
+		},
+	},
+	{
+		Name: "Health", Slug: "health",
+		Aliases: []string{"health"},
+		Keywords: map[string]float64{
+			"health": 1.0, "medical": 1.0, "medicine": 1.0, "doctor": 0.9, "hospital": 0.9,
+			"disease": 0.9, "treatment": 0.9, "cure": 0.9, "patient": 0.9, "therapy": 0.9,
+			"diet": 0.9, "fitness": 0.9, "wellness": 0.9, "virus": 0.9, "pandemic": 0.9,
+			"vaccine": 0.9, "symptom": 0.9, "diagnosis": 0.9, "surgery": 0.9, "prescription": 0.9,
+		},
+	},
+	{
+		Name: "Science", Slug: "science",
+		Aliases: []string{"science"},
+		Keywords: map[string]float64{
+			"science": 1.0, "research": 0.9, "study": 0.8, "discover": 0.9, "experiment": 0.9,
+			"scientist": 1.0, "laboratory": 0.9, "physics": 1.0, "chemistry": 1.0, "biology": 1.0,
+			"astronomy": 1.0, "space": 0.9, "theory": 0.8, "hypothesis": 0.9, "scientific": 1.0,
+			"molecule": 0.9, "atom": 0.9, "quantum": 1.0, "genetic": 0.9, "evolution": 0.9,
+		},
+	},
+}
+
+// taxonomy caches the categories table, which is read for every article
+// categorized. It is reloaded whenever an admin edits a category.
+var taxonomy struct {
+	sync.RWMutex
+	categories []Category
+}
+
+func currentCategories() []Category {
+	taxonomy.RLock()
+	defer taxonomy.RUnlock()
+	return taxonomy.categories
+}
+
+// categorySlugs lists every category an article can have, in display order,
+// ending with otherCategory.
+func categorySlugs() []string {
+	var slugs []string
+	for _, c := range currentCategories() {
+		slugs = append(slugs, c.Slug)
+	}
+	return append(slugs, otherCategory)
+}
+
+func loadCategories(db *sql.DB) error {
+	categories, err := getCategories(db)
+	if err != nil {
+		return err
+	}
+	taxonomy.Lock()
+	taxonomy.categories = categories
+	taxonomy.Unlock()
+	forgetAllSidebars()
+	return nil
+}
+
+// matchCategoryAlias returns the first category, in display order, with an
+// alias contained in text, or "" if none matches.
+func matchCategoryAlias(text string) string {
+	text = strings.ToLower(text)
+	for _, c := range currentCategories() {
+		for _, alias := range c.Aliases {
+			if alias != "" && strings.Contains(text, alias) {
+				return c.Slug
+			}
+		}
+	}
+	return ""
+}
+
+func getCategories(db *sql.DB) ([]Category, error) {
+	rows, err := db.Query("SELECT id, name, slug, position, IFNULL(keywords, ''), IFNULL(aliases, '') FROM categories ORDER BY position, name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var categories []Category
+	for rows.Next() {
+		var c Category
+		var keywords, aliases string
+		if err := rows.Scan(&c.ID, &c.Name, &c.Slug, &c.Position, &keywords, &aliases); err != nil {
+			return nil, err
+		}
+		if c.Keywords, err = parseCategoryKeywords(keywords); err != nil {
+			log.Printf("[WARN] Category %s has invalid keywords: %v", c.Slug, err)
+		}
+		c.Aliases = parseCategoryAliases(aliases)
+		categories = append(categories, c)
+	}
+	return categories, rows.Err()
+}
+
+func seedCategories(db *sql.DB) error {
+	for i, c := range defaultCategories {
+		c.Position = i + 1
+		if err := insertCategory(db, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func insertCategory(db *sql.DB, c Category) error {
+	_, err := db.Exec("INSERT INTO categories (name, slug, position, keywords, aliases) VALUES (?, ?, ?, ?, ?)",
+		c.Name, c.Slug, c.Position, c.KeywordsText(), c.AliasesText())
+	return err
+}
+
+// updateCategory saves an edited category. Renaming the slug moves the
+// articles and labelled examples filed under the old one.
+func updateCategory(db *sql.DB, c Category) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var oldSlug string
+	if err := tx.QueryRow("SELECT slug FROM categories WHERE id = ?", c.ID).Scan(&oldSlug); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE categories SET name = ?, slug = ?, position = ?, keywords = ?, aliases = ? WHERE id = ?",
+		c.Name, c.Slug, c.Position, c.KeywordsText(), c.AliasesText(), c.ID); err != nil {
+		return err
+	}
+	if oldSlug != c.Slug {
+		if err := moveCategory(tx, oldSlug, c.Slug); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// deleteCategory removes a category and files its articles under "other".
+func deleteCategory(db *sql.DB, id int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var slug string
+	if err := tx.QueryRow("SELECT slug FROM categories WHERE id = ?", id).Scan(&slug); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM categories WHERE id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE articles SET category = ? WHERE category = ?", otherCategory, slug); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func moveCategory(tx *sql.Tx, from, to string) error {
+	if _, err := tx.Exec("UPDATE articles SET category = ? WHERE category = ?", to, from); err != nil {
+		return err
+	}
+	_, err := tx.Exec("UPDATE category_examples SET category = ? WHERE category = ?", to, from)
+	return err
+}
+
+// KeywordsText formats the keyword weights for editing and storage as
+// "keyword:weight" pairs, heaviest first.
+func (c Category) KeywordsText() string {
+	keywords := make([]string, 0, len(c.Keywords))
+	for k := range c.Keywords {
+		keywords = append(keywords, k)
+	}
+	sort.Slice(keywords, func(i, j int) bool {
+		if c.Keywords[keywords[i]] != c.Keywords[keywords[j]] {
+			return c.Keywords[keywords[i]] > c.Keywords[keywords[j]]
+		}
+		return keywords[i] < keywords[j]
+	})
+	pairs := make([]string, len(keywords))
+	for i, k := range keywords {
+		pairs[i] = k + ":" + strconv.FormatFloat(c.Keywords[k], 'f', -1, 64)
+	}
+	return strings.Join(pairs, ", ")
+}
+
+func (c Category) AliasesText() string {
+	return strings.Join(c.Aliases, ", ")
+}
+
+// parseCategoryKeywords reads comma-separated "keyword:weight" pairs. A
+// keyword without a weight counts 1.
+func parseCategoryKeywords(text string) (map[string]float64, error) {
+	keywords := make(map[string]float64)
+	for _, pair := range strings.Split(text, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		keyword, weightText, hasWeight := strings.Cut(pair, ":")
+		keyword = strings.ToLower(strings.TrimSpace(keyword))
+		if keyword == "" {
+			return keywords, fmt.Errorf("missing keyword in %q", pair)
+		}
+		weight := 1.0
+		if hasWeight {
+			var err error
+			weight, err = strconv.ParseFloat(strings.TrimSpace(weightText), 64)
+			if err != nil || weight <= 0 {
+				return keywords, fmt.Errorf("invalid weight for %q", keyword)
+			}
+		}
+		keywords[keyword] = weight
+	}
+	return keywords, nil
+}
+
+func parseCategoryAliases(text string) []string {
+	var aliases []string
+	for _, a := range strings.Split(text, ",") {
+		if a = strings.ToLower(strings.TrimSpace(a)); a != "" {
+			aliases = append(aliases, a)
+		}
+	}
+	return aliases
+}
+
+// categorySlug derives a URL-safe slug from a category name.
+func categorySlug(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(strings.TrimSpace(name)) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+			dash = false
+		case !dash && b.Len() > 0:
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+func adminCategoriesHandler(w http.ResponseWriter, r *http.Request) {
+	categories, err := getCategories(db)
+	if err != nil {
+		http.Error(w, "Failed to load categories: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	renderTemplate(w, r, "categories.html", PageData{
+		Active:     "categories",
+		Categories: categories,
+	})
+}
+
+// saveCategoryHandler creates a category, or updates one when the form
+// carries its ID.
+func saveCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	c := Category{Name: strings.TrimSpace(r.FormValue("name"))}
+	if c.Name == "" {
+		http.Error(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+	c.Slug = categorySlug(r.FormValue("slug"))
+	if c.Slug == "" {
+		c.Slug = categorySlug(c.Name)
+	}
+	if c.Slug == "" || c.Slug == otherCategory {
+		http.Error(w, "Invalid slug", http.StatusBadRequest)
+		return
+	}
+	var err error
+	if c.Keywords, err = parseCategoryKeywords(r.FormValue("keywords")); err != nil {
+		http.Error(w, "Invalid keywords: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.Aliases = parseCategoryAliases(r.FormValue("aliases"))
+	c.Position, _ = strconv.Atoi(r.FormValue("position"))
+
+	if id := r.FormValue("id"); id != "" {
+		if c.ID, err = strconv.Atoi(id); err != nil {
+			http.Error(w, "Invalid category ID", http.StatusBadRequest)
+			return
+		}
+		err = updateCategory(db, c)
+	} else {
+		if c.Position == 0 {
+			c.Position = len(currentCategories()) + 1
+		}
+		err = insertCategory(db, c)
+	}
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			http.Error(w, "A category with that slug already exists", http.StatusConflict)
+		} else {
+			http.Error(w, "Failed to save category: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	reloadTaxonomy(db)
+	http.Redirect(w, r, "/admin/categories", http.StatusSeeOther)
+}
+
+func deleteCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.Atoi(r.URL.Path[len("/admin/categories/delete/"):])
+	if err != nil {
+		http.Error(w, "Invalid category ID", http.StatusBadRequest)
+		return
+	}
+	if err := deleteCategory(db, id); err != nil {
+		http.Error(w, "Failed to delete category: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	reloadTaxonomy(db)
+	http.Redirect(w, r, "/admin/categories", http.StatusSeeOther)
+}
+
+// reloadTaxonomy refreshes the cached categories and retrains the
+// classifier, whose labels may have been renamed or removed.
+func reloadTaxonomy(db *sql.DB) {
+	if err := loadCategories(db); err != nil {
+		log.Printf("[ERROR] Error loading categories: %v", err)
+	}
+	if err := trainCategoryModel(db); err != nil {
+		log.Printf("[ERROR] Error retraining category classifier: %v", err)
+	}
+}
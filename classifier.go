@@ -0,0 +1,390 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/jdkato/prose/v2"
+)
+
+// CATEGORY_BLEND_EXAMPLES is the number of labelled examples at which the
+// learned classifier and the keyword vectors count equally. With fewer
+// examples the keywords dominate, with more the classifier does.
+var categoryBlendExamples = getEnvInt("CATEGORY_BLEND_EXAMPLES", 50)
+
+// The learned classifier is ignored until it has seen this many examples
+// in at least two categories; before that it would file everything under
+// whichever category was corrected first.
+const categoryMinExamples = 10
+
+// categoryEvalFolds is the number of folds used to cross-validate the
+// classifier in the evaluation report.
+const categoryEvalFolds = 5
+
+var categoryStopWords = map[string]bool{
+	"the": true, "and": true, "for": true, "are": true, "but": true, "not": true,
+	"you": true, "all": true, "any": true, "can": true, "had": true, "her": true,
+	"was": true, "one": true, "our": true, "out": true, "has": true, "his": true,
+	"how": true, "its": true, "new": true, "now": true, "who": true, "did": true,
+	"get": true, "him": true, "she": true, "too": true, "use": true, "that": true,
+	"with": true, "have": true, "this": true, "will": true, "your": true,
+	"from": true, "they": true, "been": true, "were": true, "said": true,
+	"what": true, "when": true, "which": true, "their": true, "there": true,
+	"would": true, "about": true, "after": true, "could": true, "into": true,
+	"more": true, "than": true, "them": true, "then": true, "these": true,
+	"some": true, "also": true, "over": true, "just": true, "says": true,
+}
+
+// naiveBayesModel is a multinomial Naive Bayes classifier over the words of
+// labelled articles, with add-one smoothing.
+type naiveBayesModel struct {
+	docs       int
+	docCounts  map[string]int
+	wordCounts map[string]map[string]int
+	totalWords map[string]int
+	vocabulary map[string]bool
+}
+
+func newNaiveBayesModel() *naiveBayesModel {
+	return &naiveBayesModel{
+		docCounts:  make(map[string]int),
+		wordCounts: make(map[string]map[string]int),
+		totalWords: make(map[string]int),
+		vocabulary: make(map[string]bool),
+	}
+}
+
+func (m *naiveBayesModel) add(category string, words []string) {
+	m.docs++
+	m.docCounts[category]++
+	if m.wordCounts[category] == nil {
+		m.wordCounts[category] = make(map[string]int)
+	}
+	for _, w := range words {
+		m.wordCounts[category][w]++
+		m.totalWords[category]++
+		m.vocabulary[w] = true
+	}
+}
+
+// ready reports whether the model has seen enough examples to be used.
+func (m *naiveBayesModel) ready() bool {
+	return m != nil && m.docs >= categoryMinExamples && len(m.docCounts) >= 2
+}
+
+// posterior returns the probability of each category given the words, or
+// nil when the model is not ready.
+func (m *naiveBayesModel) posterior(words []string) map[string]float64 {
+	if !m.ready() {
+		return nil
+	}
+	vocab := float64(len(m.vocabulary))
+	logProbs := make(map[string]float64, len(m.docCounts))
+	maxLog := math.Inf(-1)
+	for category, count := range m.docCounts {
+		lp := math.Log(float64(count) / float64(m.docs))
+		denominator := float64(m.totalWords[category]) + vocab
+		for _, w := range words {
+			if !m.vocabulary[w] {
+				continue
+			}
+			lp += math.Log(float64(m.wordCounts[category][w]+1) / denominator)
+		}
+		logProbs[category] = lp
+		maxLog = math.Max(maxLog, lp)
+	}
+	// Normalize in log space so long texts don't underflow
+	var sum float64
+	probs := make(map[string]float64, len(logProbs))
+	for category, lp := range logProbs {
+		probs[category] = math.Exp(lp - maxLog)
+		sum += probs[category]
+	}
+	for category := range probs {
+		probs[category] /= sum
+	}
+	return probs
+}
+
+// categoryModel is the classifier trained from user corrections, rebuilt
+// at startup and whenever a correction is saved.
+var categoryModel = struct {
+	sync.RWMutex
+	model *naiveBayesModel
+}{model: newNaiveBayesModel()}
+
+func currentCategoryModel() *naiveBayesModel {
+	categoryModel.RLock()
+	defer categoryModel.RUnlock()
+	return categoryModel.model
+}
+
+func trainCategoryModel(db *sql.DB) error {
+	examples, err := getCategoryExamples(db)
+	if err != nil {
+		return err
+	}
+	model := newNaiveBayesModel()
+	for _, e := range examples {
+		model.add(e.Category, categoryWords(tokenizeForCategories(e.Text, false)))
+	}
+	categoryModel.Lock()
+	categoryModel.model = model
+	categoryModel.Unlock()
+	log.Printf("Trained category classifier on %d examples", model.docs)
+	return nil
+}
+
+// tokenizeForCategories splits text with prose. POS tags are only needed
+// by the keyword scorer.
+func tokenizeForCategories(text string, tagged bool) []prose.Token {
+	doc, err := prose.NewDocument(text,
+		prose.WithTagging(tagged),
+		prose.WithSegmentation(false),
+		prose.WithExtraction(false))
+	if err != nil {
+		log.Printf("Error creating prose document: %v", err)
+		return nil
+	}
+	return doc.Tokens()
+}
+
+// categoryWords reduces tokens to the lowercased words the classifier is
+// trained on, dropping punctuation, numbers and stop words.
+func categoryWords(tokens []prose.Token) []string {
+	var words []string
+	for _, tok := range tokens {
+		w := strings.ToLower(tok.Text)
+		if len(w) < 3 || categoryStopWords[w] || strings.IndexFunc(w, unicode.IsLetter) == -1 {
+			continue
+		}
+		words = append(words, w)
+	}
+	return words
+}
+
+// blendCategoryScores mixes keyword scores, normalized to sum to one, with
+// the classifier's probabilities, weighting the classifier by how many
+// examples it was trained on.
+func blendCategoryScores(keyword, learned map[string]float64, examples int) map[string]float64 {
+	if len(learned) == 0 {
+		return keyword
+	}
+	weight := float64(examples) / float64(examples+categoryBlendExamples)
+	var total float64
+	for _, score := range keyword {
+		total += score
+	}
+	blended := make(map[string]float64)
+	for category, score := range keyword {
+		blended[category] += (1 - weight) * score / total
+	}
+	for category, p := range learned {
+		blended[category] += weight * p
+	}
+	return blended
+}
+
+// CategoryExample is an article category confirmed by a user. The text is
+// kept so the example outlives the article.
+type CategoryExample struct {
+	ArticleID int
+	UserID    int
+	Text      string
+	Category  string
+	CreatedAt time.Time
+}
+
+// getCategoryExamples returns the labelled examples whose category is
+// still part of the taxonomy.
+func getCategoryExamples(db *sql.DB) ([]CategoryExample, error) {
+	slugs := categorySlugs()
+	rows, err := db.Query("SELECT article_id, user_id, text, category, created_at FROM category_examples ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var examples []CategoryExample
+	for rows.Next() {
+		var e CategoryExample
+		if err := rows.Scan(&e.ArticleID, &e.UserID, &e.Text, &e.Category, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		if containsString(slugs, e.Category) {
+			examples = append(examples, e)
+		}
+	}
+	return examples, rows.Err()
+}
+
+// saveCategoryCorrection records the user's category for an article as a
+// labelled example and recategorizes the article.
+func saveCategoryCorrection(db *sql.DB, userID int, article Article, category string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`
+		INSERT INTO category_examples (article_id, user_id, text, category, created_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(article_id, user_id) DO UPDATE SET category = excluded.category, created_at = excluded.created_at
+	`, article.ID, userID, articleText(article.Title, article.Summary), category, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE articles SET category = ? WHERE id = ?", category, article.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CategoryMetrics is the precision and recall of one category.
+type CategoryMetrics struct {
+	Category  string
+	Support   int // labelled examples in the category
+	Predicted int // examples predicted as the category
+	Correct   int
+	Precision float64
+	Recall    float64
+}
+
+// ClassifierEvaluation scores one way of categorizing against the labelled
+// examples.
+type ClassifierEvaluation struct {
+	Method     string
+	Accuracy   float64
+	Categories []CategoryMetrics
+}
+
+// evaluateCategorizers cross-validates the keyword vectors, the learned
+// classifier and their blend against every labelled example. The learned
+// classifier is always scored on examples it was not trained on.
+func evaluateCategorizers(db *sql.DB) (int, []ClassifierEvaluation, error) {
+	examples, err := getCategoryExamples(db)
+	if err != nil {
+		return 0, nil, err
+	}
+	tokens := make([][]prose.Token, len(examples))
+	words := make([][]string, len(examples))
+	for i, e := range examples {
+		tokens[i] = tokenizeForCategories(e.Text, true)
+		words[i] = categoryWords(tokens[i])
+	}
+
+	methods := []string{"Keywords", "Naive Bayes", "Blended"}
+	predictions := make([][]string, len(methods))
+	for m := range methods {
+		predictions[m] = make([]string, len(examples))
+	}
+	folds := min(categoryEvalFolds, len(examples))
+	for fold := 0; fold < folds; fold++ {
+		model := newNaiveBayesModel()
+		for i, e := range examples {
+			if i%folds != fold {
+				model.add(e.Category, words[i])
+			}
+		}
+		for i := fold; i < len(examples); i += folds {
+			keyword := keywordCategoryScores(tokens[i])
+			learned := model.posterior(words[i])
+			predictions[0][i] = topCategory(keyword)
+			predictions[1][i] = topCategory(learned)
+			predictions[2][i] = topCategory(blendCategoryScores(keyword, learned, model.docs))
+		}
+	}
+
+	evaluations := make([]ClassifierEvaluation, len(methods))
+	for m, method := range methods {
+		evaluations[m] = scorePredictions(method, examples, predictions[m])
+	}
+	return len(examples), evaluations, nil
+}
+
+func scorePredictions(method string, examples []CategoryExample, predicted []string) ClassifierEvaluation {
+	metrics := make(map[string]*CategoryMetrics)
+	slugs := categorySlugs()
+	for _, name := range slugs {
+		metrics[name] = &CategoryMetrics{Category: name}
+	}
+	get := func(name string) *CategoryMetrics {
+		if metrics[name] == nil {
+			metrics[name] = &CategoryMetrics{Category: name}
+		}
+		return metrics[name]
+	}
+	correct := 0
+	for i, e := range examples {
+		get(e.Category).Support++
+		get(predicted[i]).Predicted++
+		if predicted[i] == e.Category {
+			get(e.Category).Correct++
+			correct++
+		}
+	}
+
+	eval := ClassifierEvaluation{Method: method}
+	if len(examples) > 0 {
+		eval.Accuracy = float64(correct) / float64(len(examples))
+	}
+	for _, name := range slugs {
+		m := metrics[name]
+		if m.Predicted > 0 {
+			m.Precision = float64(m.Correct) / float64(m.Predicted)
+		}
+		if m.Support > 0 {
+			m.Recall = float64(m.Correct) / float64(m.Support)
+		}
+		eval.Categories = append(eval.Categories, *m)
+	}
+	return eval
+}
+
+// categoryCorrectionHandler stores the category a user picked on the
+// article page and retrains the classifier with it.
+func categoryCorrectionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.URL.Path[len("/article/category/"):]
+	category := r.FormValue("category")
+	if !containsString(categorySlugs(), category) {
+		http.Error(w, "Unknown category", http.StatusBadRequest)
+		return
+	}
+	user := currentUser(r)
+	article, err := getArticleByID(db, user.ID, id)
+	if err != nil {
+		http.Error(w, "Article not found", http.StatusNotFound)
+		return
+	}
+	if err := saveCategoryCorrection(db, user.ID, article, category); err != nil {
+		log.Printf("[ERROR] Error saving category correction: %v", err)
+		http.Error(w, "Failed to save category", http.StatusInternalServerError)
+		return
+	}
+	if err := trainCategoryModel(db); err != nil {
+		log.Printf("[ERROR] Error retraining category classifier: %v", err)
+	}
+	http.Redirect(w, r, fmt.Sprintf("/article/%d", article.ID), http.StatusSeeOther)
+}
+
+func classifierReportHandler(w http.ResponseWriter, r *http.Request) {
+	count, evaluations, err := evaluateCategorizers(db)
+	if err != nil {
+		http.Error(w, "Failed to evaluate classifier: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	renderTemplate(w, r, "classifier.html", PageData{
+		Active:      "classifier",
+		Count:       count,
+		Evaluations: evaluations,
+	})
+}
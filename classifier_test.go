@@ -0,0 +1,65 @@
+package main
+
+import "testing"
+
+// useCategories installs a taxonomy for the duration of a test.
+func useCategories(t *testing.T, categories []Category) {
+	t.Helper()
+	taxonomy.Lock()
+	previous := taxonomy.categories
+	taxonomy.categories = categories
+	taxonomy.Unlock()
+	t.Cleanup(func() {
+		taxonomy.Lock()
+		taxonomy.categories = previous
+		taxonomy.Unlock()
+	})
+}
+
+func TestKeywordCategoryScores(t *testing.T) {
+	useCategories(t, []Category{
+		{Slug: "technology", Keywords: map[string]float64{"artificial intelligence": 1, "machine learning": 0.5, "software": 0.8}},
+		{Slug: "sports", Keywords: map[string]float64{"match": 0.5, "world cup": 1}},
+	})
+	tests := []struct {
+		text string
+		want map[string]float64
+	}{
+		{"Artificial intelligence is changing medicine", map[string]float64{"technology": 1}},
+		{"New software uses machine learning and artificial intelligence", map[string]float64{"technology": 2.3}},
+		{"The World Cup final match drew millions", map[string]float64{"sports": 1.5}},
+		{"An artificial lake and intelligence reports", map[string]float64{}},
+		{"Machine learning, machine learning everywhere", map[string]float64{"technology": 1}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.text, func(t *testing.T) {
+			got := keywordCategoryScores(tokenizeForCategories(tc.text, true))
+			if len(got) != len(tc.want) {
+				t.Fatalf("scores = %v, want %v", got, tc.want)
+			}
+			for slug, want := range tc.want {
+				if diff := got[slug] - want; diff > 1e-9 || diff < -1e-9 {
+					t.Errorf("%s = %v, want %v (all scores %v)", slug, got[slug], want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestCountPhrase(t *testing.T) {
+	words := []string{"a", "b", "a", "b", "c"}
+	tests := []struct {
+		phrase []string
+		want   int
+	}{
+		{[]string{"a", "b"}, 2},
+		{[]string{"b", "c"}, 1},
+		{[]string{"c", "a"}, 0},
+		{[]string{"a", "b", "c", "d", "e", "f"}, 0},
+	}
+	for _, tc := range tests {
+		if got := countPhrase(words, tc.phrase); got != tc.want {
+			t.Errorf("countPhrase(%v) = %d, want %d", tc.phrase, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// runCommand runs a one-off maintenance command given on the command line
+// instead of starting the server, e.g. `suprnews backfill-sentiment`.
+func runCommand(db *sql.DB, args []string) error {
+	switch args[0] {
+	case "backfill-sentiment":
+		n, err := backfillSentiment(db)
+		if err != nil {
+			return err
+		}
+		log.Printf("Scored sentiment for %d articles", n)
+		return nil
+	case "backfill-entities":
+		n, err := backfillEntities(db)
+		if err != nil {
+			return err
+		}
+		log.Printf("Extracted entities for %d articles", n)
+		return nil
+	case "backfill-stories":
+		n, err := backfillStories(db)
+		if err != nil {
+			return err
+		}
+		log.Printf("Clustered %d articles into stories", n)
+		return nil
+	case "rebuild-search-index":
+		n, err := rebuildSearchIndex(db)
+		if err != nil {
+			return err
+		}
+		log.Printf("Indexed %d articles for search", n)
+		return nil
+	case "retention-report":
+		reports, err := planRetention(db, time.Now())
+		if err != nil {
+			return err
+		}
+		total := 0
+		for _, r := range reports {
+			if len(r.Expired) == 0 && r.Starred == 0 {
+				continue
+			}
+			fmt.Printf("%s: %d of %d articles would be deleted", r.Feed.Name, len(r.Expired), r.Stored)
+			if r.Starred > 0 {
+				fmt.Printf(", %d kept because they are starred", r.Starred)
+			}
+			fmt.Println()
+			for _, a := range r.Expired {
+				fmt.Printf("  %s  %-5s  %s\n", a.PublishedAt.Format("2006-01-02 15:04"), a.Reason, a.Title)
+			}
+			total += len(r.Expired)
+		}
+		fmt.Printf("%d articles would be deleted by the next cleanup\n", total)
+		return nil
+	case "evaluate-categories":
+		count, evaluations, err := evaluateCategorizers(db)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d labelled examples\n", count)
+		for _, e := range evaluations {
+			fmt.Printf("\n%s (accuracy %.1f%%)\n", e.Method, e.Accuracy*100)
+			fmt.Printf("  %-14s %8s %9s %9s %7s\n", "category", "examples", "predicted", "precision", "recall")
+			for _, c := range e.Categories {
+				fmt.Printf("  %-14s %8d %9d %8.1f%% %6.1f%%\n", c.Category, c.Support, c.Predicted, c.Precision*100, c.Recall*100)
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
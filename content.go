@@ -0,0 +1,87 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// Content extraction methods and outcomes stored with each article.
+const (
+	extractionReadability = "readability"
+	extractionPlainHTML   = "plain"
+
+	contentStatusOK     = "ok"
+	contentStatusFailed = "failed"
+)
+
+var contentPrefetchWorkers = getEnvInt("CONTENT_PREFETCH_WORKERS", 2)
+
+// extractionJob identifies an article whose page should be extracted.
+type extractionJob struct {
+	ArticleID int
+	URL       string
+}
+
+// extractionQueue feeds the background prefetcher. It is buffered so that
+// ingestion never blocks on extraction; anything dropped when it is full is
+// picked up by the periodic sweep in queuePendingExtractions.
+var extractionQueue = make(chan extractionJob, 500)
+
+// runContentPrefetcher extracts full text for newly ingested articles so
+// opening them later does not hit the origin site.
+func runContentPrefetcher(db *sql.DB) {
+	workers := contentPrefetchWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for job := range extractionQueue {
+				if _, err := extractAndStoreContent(db, job.ArticleID, job.URL); err != nil {
+					log.Printf("[ERROR] Error storing content for article %d: %v", job.ArticleID, err)
+				}
+			}
+		}()
+	}
+}
+
+func queueContentExtraction(articleID int, url string) {
+	select {
+	case extractionQueue <- extractionJob{ArticleID: articleID, URL: url}:
+	default:
+		log.Printf("[WARN] Extraction queue full, article %d will be picked up later", articleID)
+	}
+}
+
+// queuePendingExtractions re-queues articles that were never extracted, for
+// example because the queue was full or the process restarted.
+func queuePendingExtractions(db *sql.DB) error {
+	articles, err := getPendingExtractions(db, 50)
+	if err != nil {
+		return err
+	}
+	for _, a := range articles {
+		queueContentExtraction(a.ID, a.URL)
+	}
+	return nil
+}
+
+// extractAndStoreContent fetches the article page once and persists the
+// extracted HTML together with how and when it was obtained.
+func extractAndStoreContent(db *sql.DB, articleID int, url string) (Article, error) {
+	content, method := fetchArticleContent(url)
+	status := contentStatusOK
+	if content == "No content available" || isBinaryOrGarbled(content) {
+		status = contentStatusFailed
+		content = ""
+	}
+	a := Article{
+		ID:                 articleID,
+		Content:            content,
+		ContentMethod:      method,
+		ContentStatus:      status,
+		ContentExtractedAt: time.Now(),
+	}
+	return a, saveArticleContent(db, articleID, content, method, status, a.ContentExtractedAt)
+}
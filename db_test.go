@@ -0,0 +1,37 @@
+//go:build sqlite_fts5
+
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+// openTestDB returns a freshly initialized database in a temporary
+// directory. Tests that need it are built with the sqlite_fts5 tag, like
+// the server itself.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	testDB, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "suprnews.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { testDB.Close() })
+	if err := initDB(testDB); err != nil {
+		t.Fatal(err)
+	}
+	return testDB
+}
+
+func createTestUser(t *testing.T, db *sql.DB, username string) int {
+	t.Helper()
+	if err := createUser(db, username, "password"); err != nil {
+		t.Fatal(err)
+	}
+	var id int
+	if err := db.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
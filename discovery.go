@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/mmcdole/gofeed"
+)
+
+// FeedCandidate is a feed found while resolving a URL the user pasted.
+type FeedCandidate struct {
+	URL   string
+	Title string
+}
+
+// feedLinkTypes are the <link rel="alternate"> types that point at feeds.
+var feedLinkTypes = []string{
+	"application/rss+xml",
+	"application/atom+xml",
+	"application/feed+json",
+	"application/json",
+}
+
+// commonFeedPaths are probed when a site does not advertise its feed.
+var commonFeedPaths = []string{
+	"/feed",
+	"/rss",
+	"/rss.xml",
+	"/feed.xml",
+	"/atom.xml",
+	"/index.xml",
+}
+
+// discoverFeeds resolves a pasted URL to one or more feeds. A URL that is
+// already a feed is returned as is; otherwise the page's alternate links and
+// a few common feed paths are checked.
+func discoverFeeds(pageURL string) ([]FeedCandidate, error) {
+	base, err := url.Parse(pageURL)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") {
+		return nil, fmt.Errorf("invalid URL %q", pageURL)
+	}
+	client := &http.Client{Timeout: 15 * time.Second}
+
+	page, err := fetchDiscoveryDocument(client, base.String())
+	if err != nil {
+		return nil, err
+	}
+	if parsed, err := gofeed.NewParser().Parse(bytes.NewReader(page.Body)); err == nil {
+		return []FeedCandidate{{URL: page.URL.String(), Title: strings.TrimSpace(parsed.Title)}}, nil
+	}
+
+	var hrefs []string
+	if doc, err := goquery.NewDocumentFromReader(bytes.NewReader(page.Body)); err == nil {
+		doc.Find("link[rel~='alternate'][href]").Each(func(_ int, s *goquery.Selection) {
+			linkType := strings.ToLower(strings.TrimSpace(s.AttrOr("type", "")))
+			for _, t := range feedLinkTypes {
+				if linkType == t {
+					hrefs = append(hrefs, s.AttrOr("href", ""))
+					break
+				}
+			}
+		})
+	}
+	hrefs = append(hrefs, commonFeedPaths...)
+
+	var candidates []FeedCandidate
+	seen := make(map[string]bool)
+	for _, href := range hrefs {
+		ref, err := url.Parse(strings.TrimSpace(href))
+		if err != nil {
+			continue
+		}
+		candidateURL := page.URL.ResolveReference(ref).String()
+		if seen[candidateURL] {
+			continue
+		}
+		seen[candidateURL] = true
+
+		title, err := probeFeed(client, candidateURL)
+		if err != nil {
+			log.Printf("[DEBUG] Discovery candidate %s rejected: %v", candidateURL, err)
+			continue
+		}
+		candidates = append(candidates, FeedCandidate{URL: candidateURL, Title: title})
+	}
+	return candidates, nil
+}
+
+// fetchedDocument is a page or feed downloaded during discovery.
+type fetchedDocument struct {
+	Body   []byte
+	URL    *url.URL // final URL after redirects
+	Header http.Header
+}
+
+// fetchDiscoveryDocument downloads a page or feed, following redirects.
+func fetchDiscoveryDocument(client *http.Client, target string) (*fetchedDocument, error) {
+	req, err := http.NewRequest("GET", target, nil)
+	if err != nil {
+		return nil, err
+	}
+	setFeedRequestHeaders(req)
+	req.Header.Set("Accept", req.Header.Get("Accept")+", text/html;q=0.7")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &httpStatusError{StatusCode: resp.StatusCode, Header: resp.Header}
+	}
+	body, err := readFeedBody(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &fetchedDocument{Body: body, URL: resp.Request.URL, Header: resp.Header}, nil
+}
+
+// probeFeed checks that a URL serves a parseable feed and returns its title.
+func probeFeed(client *http.Client, feedURL string) (string, error) {
+	doc, err := fetchDiscoveryDocument(client, feedURL)
+	if err != nil {
+		return "", err
+	}
+	parsed, err := gofeed.NewParser().Parse(bytes.NewReader(doc.Body))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(parsed.Title), nil
+}
+
+// canonicalFeedURL normalizes a feed URL for duplicate detection: the
+// scheme, a leading "www.", default ports, fragments, trailing slashes and
+// query parameter order do not make two feeds different.
+func canonicalFeedURL(raw string) string {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || u.Host == "" {
+		return strings.ToLower(strings.TrimSpace(raw))
+	}
+	host := strings.ToLower(u.Hostname())
+	host = strings.TrimPrefix(host, "www.")
+	if port := u.Port(); port != "" && port != "80" && port != "443" {
+		host += ":" + port
+	}
+	path := strings.TrimRight(u.EscapedPath(), "/")
+	canonical := host + path
+	if u.RawQuery != "" {
+		canonical += "?" + u.Query().Encode()
+	}
+	return canonical
+}
+
+// FeedPreview summarizes a candidate feed before the user subscribes.
+type FeedPreview struct {
+	URL         string
+	Title       string
+	Description string
+	FeedType    string
+	ItemCount   int
+	Items       []FeedPreviewItem
+	Warnings    []string
+}
+
+type FeedPreviewItem struct {
+	Title       string
+	Link        string
+	PublishedAt time.Time
+}
+
+// previewFeed fetches and parses a single feed URL without storing
+// anything, collecting warnings about items we would not ingest cleanly.
+func previewFeed(feedURL string) (*FeedPreview, error) {
+	client := &http.Client{Timeout: 15 * time.Second}
+	doc, err := fetchDiscoveryDocument(client, feedURL)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := gofeed.NewParser().Parse(bytes.NewReader(doc.Body))
+	if err != nil {
+		return nil, fmt.Errorf("not a valid RSS, Atom or JSON feed: %v", err)
+	}
+
+	preview := &FeedPreview{
+		URL:         doc.URL.String(),
+		Title:       strings.TrimSpace(parsed.Title),
+		Description: strings.TrimSpace(parsed.Description),
+		FeedType:    parsed.FeedType + " " + parsed.FeedVersion,
+		ItemCount:   len(parsed.Items),
+	}
+	if preview.URL != feedURL {
+		preview.Warnings = append(preview.Warnings, "The feed redirects to "+preview.URL+"; that address will be used.")
+	}
+	if ct := strings.ToLower(doc.Header.Get("Content-Type")); strings.HasPrefix(ct, "text/html") {
+		preview.Warnings = append(preview.Warnings, "The server labels the feed as "+ct+" rather than a feed type.")
+	}
+	if preview.Title == "" {
+		preview.Warnings = append(preview.Warnings, "The feed has no title.")
+	}
+	if len(parsed.Items) == 0 {
+		preview.Warnings = append(preview.Warnings, "The feed has no items.")
+	}
+
+	var noLink, noDate, tooOld int
+	policy := defaultRetention()
+	for _, item := range parsed.Items {
+		if item.Link == "" {
+			noLink++
+		}
+		if item.PublishedParsed == nil {
+			noDate++
+		} else if policy.tooOldToIngest(*item.PublishedParsed, time.Now()) {
+			tooOld++
+		}
+	}
+	if noLink > 0 {
+		preview.Warnings = append(preview.Warnings, fmt.Sprintf("%d item(s) have no link and cannot be stored.", noLink))
+	}
+	if noDate > 0 {
+		preview.Warnings = append(preview.Warnings, fmt.Sprintf("%d item(s) have no publication date; they will be dated when first fetched.", noDate))
+	}
+	if tooOld > 0 {
+		preview.Warnings = append(preview.Warnings, fmt.Sprintf("%d item(s) are older than %s and will be skipped.", tooOld, formatRetentionAge(policy.IngestMaxAge)))
+	}
+
+	for i, item := range parsed.Items {
+		if i == 5 {
+			break
+		}
+		p := FeedPreviewItem{Title: strings.TrimSpace(item.Title), Link: item.Link}
+		if item.PublishedParsed != nil {
+			p.PublishedAt = *item.PublishedParsed
+		}
+		preview.Items = append(preview.Items, p)
+	}
+	return preview, nil
+}
+
+// previewTTL is how long a preview is kept for the subscribe step.
+const previewTTL = 15 * time.Minute
+
+type cachedPreview struct {
+	preview *FeedPreview
+	expires time.Time
+}
+
+// recentPreviews remembers each user's previews by feed URL so that
+// subscribing to a previewed feed does not fetch it again.
+var recentPreviews = struct {
+	sync.Mutex
+	m map[string]cachedPreview
+}{m: make(map[string]cachedPreview)}
+
+func previewKey(userID int, feedURL string) string {
+	return fmt.Sprintf("%d %s", userID, feedURL)
+}
+
+func rememberPreview(userID int, preview *FeedPreview) {
+	now := time.Now()
+	recentPreviews.Lock()
+	defer recentPreviews.Unlock()
+	for key, c := range recentPreviews.m {
+		if now.After(c.expires) {
+			delete(recentPreviews.m, key)
+		}
+	}
+	recentPreviews.m[previewKey(userID, preview.URL)] = cachedPreview{preview: preview, expires: now.Add(previewTTL)}
+}
+
+// takePreview returns and forgets the user's recent preview of the feed.
+func takePreview(userID int, feedURL string) (*FeedPreview, bool) {
+	recentPreviews.Lock()
+	defer recentPreviews.Unlock()
+	key := previewKey(userID, feedURL)
+	c, ok := recentPreviews.m[key]
+	delete(recentPreviews.m, key)
+	if !ok || time.Now().After(c.expires) {
+		return nil, false
+	}
+	return c.preview, true
+}
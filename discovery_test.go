@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestFetchersRejectOversizedFeeds checks that discovery refuses a feed the
+// refresher would refuse, instead of previewing a truncated copy.
+func TestFetchersRejectOversizedFeeds(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml")
+		w.Write([]byte(`<rss version="2.0"><channel><title>big</title><description>`))
+		w.Write([]byte(strings.Repeat("x", maxFeedBodySize)))
+		w.Write([]byte(`</description></channel></rss>`))
+	}))
+	defer s.Close()
+
+	if _, err := fetchDiscoveryDocument(s.Client(), s.URL); err == nil || !strings.Contains(err.Error(), "larger than 5 MB") {
+		t.Errorf("fetchDiscoveryDocument error = %v, want the size limit", err)
+	}
+	if _, err := fetchFeedDocument(s.Client(), Feed{URL: s.URL}); err == nil || !strings.Contains(err.Error(), "larger than 5 MB") {
+		t.Errorf("fetchFeedDocument error = %v, want the size limit", err)
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jdkato/prose/v2"
+)
+
+// Entity types, mapped from the labels of prose's named entity model.
+const (
+	entityPerson       = "person"
+	entityOrganization = "organization"
+	entityPlace        = "place"
+)
+
+var entityTypesByLabel = map[string]string{
+	"PERSON":       entityPerson,
+	"ORGANIZATION": entityOrganization,
+	"GPE":          entityPlace,
+}
+
+// Entity is a person, organization or place mentioned in an article.
+type Entity struct {
+	Name  string
+	Type  string
+	Count int // mentions, when listing top entities
+}
+
+// EntityMentions is how many articles mentioned an entity on one day.
+type EntityMentions struct {
+	Day     time.Time
+	Count   int
+	Percent int // of the busiest day, for the bar width
+}
+
+// extractEntities finds the people, organizations and places in text.
+func extractEntities(text string) []Entity {
+	doc, err := prose.NewDocument(text, prose.WithSegmentation(false))
+	if err != nil {
+		log.Printf("Error creating prose document: %v", err)
+		return nil
+	}
+	seen := make(map[Entity]bool)
+	var entities []Entity
+	for _, ent := range doc.Entities() {
+		entityType, ok := entityTypesByLabel[ent.Label]
+		if !ok {
+			continue
+		}
+		e := Entity{Name: normalizeEntityName(ent.Text), Type: entityType}
+		if len([]rune(e.Name)) < 2 || seen[e] {
+			continue
+		}
+		seen[e] = true
+		entities = append(entities, e)
+	}
+	return entities
+}
+
+func normalizeEntityName(name string) string {
+	name = strings.Join(strings.Fields(name), " ")
+	name = strings.TrimSuffix(strings.TrimSuffix(name, "'s"), "’s")
+	return strings.Trim(name, ".,;:!?\"'()[]")
+}
+
+// saveArticleEntities links the article to its entities, creating entities
+// seen for the first time.
+func saveArticleEntities(db *sql.DB, articleID int, entities []Entity) error {
+	if len(entities) == 0 {
+		return nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, e := range entities {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO entities (name, type) VALUES (?, ?)", e.Name, e.Type); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`
+			INSERT OR IGNORE INTO article_entities (article_id, entity_id)
+			SELECT ?, id FROM entities WHERE name = ? AND type = ?
+		`, articleID, e.Name, e.Type); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// attachArticleEntities loads the entities of each article for its card.
+func attachArticleEntities(db *sql.DB, articles []Article) error {
+	if len(articles) == 0 {
+		return nil
+	}
+	placeholders := make([]string, len(articles))
+	args := make([]interface{}, len(articles))
+	index := make(map[int]int, len(articles))
+	for i, a := range articles {
+		placeholders[i] = "?"
+		args[i] = a.ID
+		index[a.ID] = i
+	}
+	rows, err := db.Query(`
+		SELECT ae.article_id, e.name, e.type
+		FROM article_entities ae
+		JOIN entities e ON e.id = ae.entity_id
+		WHERE ae.article_id IN (`+strings.Join(placeholders, ",")+`)
+		ORDER BY e.type, e.name
+	`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var articleID int
+		var e Entity
+		if err := rows.Scan(&articleID, &e.Name, &e.Type); err != nil {
+			return err
+		}
+		i := index[articleID]
+		articles[i].Entities = append(articles[i].Entities, e)
+	}
+	return rows.Err()
+}
+
+func getArticleEntities(db *sql.DB, articleID int) ([]Entity, error) {
+	articles := []Article{{ID: articleID}}
+	err := attachArticleEntities(db, articles)
+	return articles[0].Entities, err
+}
+
+// getTopEntities returns the entities mentioned in the most of the user's
+// articles published since the given time.
+func getTopEntities(db *sql.DB, userID int, since time.Time, limit int) ([]Entity, error) {
+	rows, err := db.Query(`
+		SELECT e.name, e.type, COUNT(DISTINCT a.id) AS mentions
+		FROM entities e
+		JOIN article_entities ae ON ae.entity_id = e.id
+		JOIN articles a ON a.id = ae.article_id
+		JOIN subscriptions s ON s.feed_id = a.feed_id AND s.user_id = ?
+		WHERE a.published_at >= ?
+		GROUP BY e.id
+		ORDER BY mentions DESC, e.name
+		LIMIT ?
+	`, userID, since.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entities []Entity
+	for rows.Next() {
+		var e Entity
+		if err := rows.Scan(&e.Name, &e.Type, &e.Count); err != nil {
+			return nil, err
+		}
+		entities = append(entities, e)
+	}
+	return entities, rows.Err()
+}
+
+// getEntityArticles returns the user's articles mentioning an entity of any
+// type with the given name, newest first.
+func getEntityArticles(db *sql.DB, userID int, name string) ([]Article, error) {
+	rows, err := db.Query(`
+		SELECT DISTINCT `+articleListColumns+`
+		FROM articles a
+		JOIN feeds f ON a.feed_id = f.id
+		JOIN subscriptions s ON s.feed_id = a.feed_id AND s.user_id = ?
+		JOIN article_entities ae ON ae.article_id = a.id
+		JOIN entities e ON e.id = ae.entity_id
+		WHERE e.name = ? COLLATE NOCASE
+		ORDER BY a.published_at DESC
+	`, userID, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanArticles(rows)
+}
+
+// entityTimeline counts the articles per day, newest day first.
+func entityTimeline(articles []Article) []EntityMentions {
+	var timeline []EntityMentions
+	for _, a := range articles {
+		day := a.PublishedAt.UTC().Truncate(24 * time.Hour)
+		if n := len(timeline); n > 0 && timeline[n-1].Day.Equal(day) {
+			timeline[n-1].Count++
+			continue
+		}
+		timeline = append(timeline, EntityMentions{Day: day, Count: 1})
+	}
+	busiest := 0
+	for _, t := range timeline {
+		busiest = max(busiest, t.Count)
+	}
+	for i := range timeline {
+		timeline[i].Percent = timeline[i].Count * 100 / busiest
+	}
+	return timeline
+}
+
+// pruneEntities drops entity links of deleted articles and entities no
+// longer mentioned anywhere.
+func pruneEntities(db *sql.DB) error {
+	if _, err := db.Exec("DELETE FROM article_entities WHERE article_id NOT IN (SELECT id FROM articles)"); err != nil {
+		return err
+	}
+	_, err := db.Exec("DELETE FROM entities WHERE id NOT IN (SELECT entity_id FROM article_entities)")
+	return err
+}
+
+// backfillEntities extracts entities for articles that have none linked,
+// for rows stored before extraction existed.
+func backfillEntities(db *sql.DB) (int, error) {
+	rows, err := db.Query(`
+		SELECT id, title, IFNULL(summary, '') FROM articles
+		WHERE id NOT IN (SELECT article_id FROM article_entities)
+	`)
+	if err != nil {
+		return 0, err
+	}
+	type pending struct {
+		id             int
+		title, summary string
+	}
+	var articles []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.title, &p.summary); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		articles = append(articles, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	for _, a := range articles {
+		if err := saveArticleEntities(db, a.id, extractEntities(articleText(a.title, a.summary))); err != nil {
+			return 0, err
+		}
+	}
+	return len(articles), nil
+}
+
+func entityHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(r.URL.Path[len("/entity/"):])
+	if name == "" {
+		http.NotFound(w, r)
+		return
+	}
+	articles, err := getEntityArticles(db, currentUser(r).ID, name)
+	if err != nil {
+		log.Printf("[ERROR] Error getting entity articles: %v", err)
+		http.Error(w, "Failed to load articles", http.StatusInternalServerError)
+		return
+	}
+	if err := attachArticleEntities(db, articles); err != nil {
+		log.Printf("[ERROR] Error getting article entities: %v", err)
+	}
+	renderTemplate(w, r, "entity.html", PageData{
+		Articles: articles,
+		Active:   "entity",
+		Query:    name,
+		Count:    len(articles),
+		Timeline: entityTimeline(articles),
+	})
+}
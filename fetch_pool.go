@@ -0,0 +1,182 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Feed fetching limits, overridable through the environment.
+var (
+	feedWorkers         = getEnvInt("FEED_WORKERS", 8)
+	feedHostConcurrency = getEnvInt("FEED_HOST_CONCURRENCY", 2)
+	feedHostDelay       = getEnvDuration("FEED_HOST_DELAY", time.Second)
+	feedTimeout         = getEnvDuration("FEED_TIMEOUT", 30*time.Second)
+)
+
+// refreshMu keeps refresh cycles from overlapping when one runs longer than
+// the polling interval.
+var refreshMu sync.Mutex
+
+// hostLimiter caps how many requests run against a single host at once and
+// spaces out request starts to the same host by a politeness delay.
+type hostLimiter struct {
+	mu       sync.Mutex
+	perHost  int
+	delay    time.Duration
+	slots    map[string]chan struct{}
+	nextSlot map[string]time.Time
+}
+
+func newHostLimiter(perHost int, delay time.Duration) *hostLimiter {
+	if perHost < 1 {
+		perHost = 1
+	}
+	return &hostLimiter{
+		perHost:  perHost,
+		delay:    delay,
+		slots:    make(map[string]chan struct{}),
+		nextSlot: make(map[string]time.Time),
+	}
+}
+
+func (h *hostLimiter) acquire(host string) {
+	h.mu.Lock()
+	sem, ok := h.slots[host]
+	if !ok {
+		sem = make(chan struct{}, h.perHost)
+		h.slots[host] = sem
+	}
+	h.mu.Unlock()
+
+	sem <- struct{}{}
+
+	h.mu.Lock()
+	now := time.Now()
+	start := h.nextSlot[host]
+	if start.Before(now) {
+		start = now
+	}
+	h.nextSlot[host] = start.Add(h.delay)
+	h.mu.Unlock()
+
+	time.Sleep(time.Until(start))
+}
+
+func (h *hostLimiter) release(host string) {
+	h.mu.Lock()
+	sem := h.slots[host]
+	h.mu.Unlock()
+	<-sem
+}
+
+// feedHost returns the lowercased host of a feed URL, used as the per-host
+// limiter key.
+func feedHost(feedURL string) string {
+	u, err := url.Parse(feedURL)
+	if err != nil || u.Host == "" {
+		return feedURL
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// fetchPool runs feed fetches on a bounded number of workers. A fetch
+// first waits for a slot on its host and only then for a worker, so feeds
+// queued behind a busy host never hold up feeds on other hosts. Scheduled
+// sweeps and one-off refreshes share the same pool and limits.
+type fetchPool struct {
+	client  *http.Client
+	workers chan struct{}
+	hosts   *hostLimiter
+
+	mu       sync.Mutex
+	inFlight map[int]bool
+}
+
+func newFetchPool(workers, perHost int, delay, timeout time.Duration) *fetchPool {
+	if workers < 1 {
+		workers = 1
+	}
+	return &fetchPool{
+		client:   &http.Client{Timeout: timeout},
+		workers:  make(chan struct{}, workers),
+		hosts:    newHostLimiter(perHost, delay),
+		inFlight: make(map[int]bool),
+	}
+}
+
+var feedPool = newFetchPool(feedWorkers, feedHostConcurrency, feedHostDelay, feedTimeout)
+
+// do fetches one feed once a host slot and a worker are free. A feed that
+// is already being fetched is skipped.
+func (p *fetchPool) do(feed Feed, fetch func(*http.Client, Feed) error) error {
+	p.mu.Lock()
+	if p.inFlight[feed.ID] {
+		p.mu.Unlock()
+		log.Printf("Feed %s is already being fetched, skipping", feed.URL)
+		return nil
+	}
+	p.inFlight[feed.ID] = true
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.inFlight, feed.ID)
+		p.mu.Unlock()
+	}()
+
+	host := feedHost(feed.URL)
+	p.hosts.acquire(host)
+	defer p.hosts.release(host)
+	p.workers <- struct{}{}
+	defer func() { <-p.workers }()
+	return fetch(p.client, feed)
+}
+
+// run fetches all the feeds and waits for them to finish, logging errors.
+func (p *fetchPool) run(feeds []Feed, fetch func(*http.Client, Feed) error) {
+	var wg sync.WaitGroup
+	for _, feed := range feeds {
+		wg.Add(1)
+		go func(feed Feed) {
+			defer wg.Done()
+			if err := p.do(feed, fetch); err != nil {
+				log.Printf("Error parsing feed %s: %v", feed.URL, err)
+			}
+		}(feed)
+	}
+	wg.Wait()
+}
+
+// refreshFeeds polls the given feeds on the fetch pool and waits for all of
+// them to finish.
+func refreshFeeds(db *sql.DB, feeds []Feed) {
+	feedPool.run(feeds, func(client *http.Client, feed Feed) error {
+		return refreshFeed(db, client, feed)
+	})
+}
+
+// refreshSingleFeed polls one feed right away, outside the scheduler but
+// within the pool's worker and per-host limits.
+func refreshSingleFeed(db *sql.DB, feedID int) error {
+	feed, err := getFeedForFetch(db, feedID)
+	if err != nil {
+		return err
+	}
+	return feedPool.do(feed, func(client *http.Client, feed Feed) error {
+		return refreshFeed(db, client, feed)
+	})
+}
+
+// refreshFeedInBackground queues a first fetch of a newly added feed
+// without making the caller wait for it.
+func refreshFeedInBackground(db *sql.DB, feedID int) {
+	go func() {
+		if err := refreshSingleFeed(db, feedID); err != nil {
+			log.Printf("Error parsing newly added feed: %v", err)
+		}
+	}()
+}
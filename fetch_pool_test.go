@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowFeedServer serves an empty RSS feed after a delay and records the
+// most requests it saw at once, per requested host.
+type slowFeedServer struct {
+	*httptest.Server
+	delay map[string]time.Duration
+
+	mu      sync.Mutex
+	active  map[string]int
+	maxHost map[string]int
+	total   int
+	maxAll  int
+}
+
+func newSlowFeedServer(t *testing.T, delay map[string]time.Duration) *slowFeedServer {
+	s := &slowFeedServer{delay: delay, active: map[string]int{}, maxHost: map[string]int{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *slowFeedServer) serve(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	s.mu.Lock()
+	s.active[host]++
+	s.total++
+	s.maxHost[host] = max(s.maxHost[host], s.active[host])
+	s.maxAll = max(s.maxAll, s.total)
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.active[host]--
+		s.total--
+		s.mu.Unlock()
+	}()
+
+	select {
+	case <-time.After(s.delay[host]):
+	case <-r.Context().Done():
+		return
+	}
+	w.Header().Set("Content-Type", "application/rss+xml")
+	fmt.Fprint(w, `<rss version="2.0"><channel><title>t</title></channel></rss>`)
+}
+
+// testPool returns a pool whose client sends every host to the server, so
+// feeds can be spread over several hostnames.
+func testPool(s *slowFeedServer, workers, perHost int, timeout time.Duration) *fetchPool {
+	p := newFetchPool(workers, perHost, 0, timeout)
+	addr := s.Listener.Addr().String()
+	p.client.Transport = &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+	return p
+}
+
+func testFeeds(hosts ...string) []Feed {
+	var feeds []Feed
+	for i, host := range hosts {
+		feeds = append(feeds, Feed{ID: i + 1, URL: fmt.Sprintf("http://%s/feed/%d", host, i)})
+	}
+	return feeds
+}
+
+func fetchOnly(client *http.Client, feed Feed) error {
+	_, err := fetchFeedDocument(client, feed)
+	return err
+}
+
+func TestFetchPoolWorkerLimit(t *testing.T) {
+	hosts := []string{"a.test", "b.test", "c.test", "d.test", "e.test", "f.test"}
+	delay := map[string]time.Duration{}
+	for _, h := range hosts {
+		delay[h] = 50 * time.Millisecond
+	}
+	s := newSlowFeedServer(t, delay)
+	p := testPool(s, 2, 4, time.Second)
+
+	var mu sync.Mutex
+	var errs []error
+	p.run(testFeeds(hosts...), func(client *http.Client, feed Feed) error {
+		err := fetchOnly(client, feed)
+		if err != nil {
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+		}
+		return err
+	})
+	if len(errs) > 0 {
+		t.Fatalf("fetch errors: %v", errs)
+	}
+	if s.maxAll != 2 {
+		t.Errorf("max concurrent fetches = %d, want 2", s.maxAll)
+	}
+}
+
+func TestFetchPoolHostLimit(t *testing.T) {
+	s := newSlowFeedServer(t, map[string]time.Duration{
+		"slow.test": 100 * time.Millisecond,
+		"a.test":    10 * time.Millisecond,
+		"b.test":    10 * time.Millisecond,
+	})
+	p := testPool(s, 2, 1, time.Second)
+
+	var mu sync.Mutex
+	done := map[string]time.Duration{}
+	start := time.Now()
+	feeds := testFeeds("slow.test", "slow.test", "slow.test", "slow.test", "a.test", "b.test")
+	p.run(feeds, func(client *http.Client, feed Feed) error {
+		err := fetchOnly(client, feed)
+		mu.Lock()
+		done[feedHost(feed.URL)] = time.Since(start)
+		mu.Unlock()
+		return err
+	})
+
+	if got := s.maxHost["slow.test"]; got != 1 {
+		t.Errorf("max concurrent fetches to slow.test = %d, want 1", got)
+	}
+	// The slow host takes 400ms in all; the other hosts must not queue
+	// behind it.
+	for _, host := range []string{"a.test", "b.test"} {
+		if done[host] > 250*time.Millisecond {
+			t.Errorf("%s finished after %v, blocked behind slow.test", host, done[host])
+		}
+	}
+	if done["slow.test"] < 400*time.Millisecond {
+		t.Errorf("slow.test finished after %v, want its fetches serialized", done["slow.test"])
+	}
+}
+
+func TestFetchPoolTimeout(t *testing.T) {
+	s := newSlowFeedServer(t, map[string]time.Duration{
+		"hung.test": 5 * time.Second,
+		"ok.test":   0,
+	})
+	p := testPool(s, 2, 2, 100*time.Millisecond)
+
+	var mu sync.Mutex
+	results := map[string]error{}
+	start := time.Now()
+	p.run(testFeeds("hung.test", "ok.test"), func(client *http.Client, feed Feed) error {
+		err := fetchOnly(client, feed)
+		mu.Lock()
+		results[feedHost(feed.URL)] = err
+		mu.Unlock()
+		return err
+	})
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("run took %v, want the hung feed cut off by the timeout", elapsed)
+	}
+	if results["hung.test"] == nil {
+		t.Error("hung.test: want a timeout error")
+	}
+	if results["ok.test"] != nil {
+		t.Errorf("ok.test: %v", results["ok.test"])
+	}
+}
+
+func TestFetchPoolSkipsFeedInFlight(t *testing.T) {
+	p := newFetchPool(4, 4, 0, time.Second)
+	feed := Feed{ID: 1, URL: "http://a.test/feed"}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var calls int
+	var mu sync.Mutex
+	fetch := func(*http.Client, Feed) error {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		close(started)
+		<-release
+		return nil
+	}
+
+	go p.do(feed, fetch)
+	<-started
+	if err := p.do(feed, fetch); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	if calls != 1 {
+		t.Errorf("fetch ran %d times, want 1", calls)
+	}
+}
@@ -1,12 +1,18 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"html/template"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -14,12 +20,51 @@ import (
 var db *sql.DB
 var templates *template.Template
 
+type contextKey string
+
+const (
+	userContextKey  contextKey = "user"
+	tokenContextKey contextKey = "token"
+)
+
 type PageData struct {
 	Articles []Article
 	Article  Article
 	Feeds    []Feed
 	Active   string
 	Query    string
+	Filter   string
+	Count    int
+	Filters  ArticleFilter
+	User     User
+
+	Page       int
+	TotalPages int
+	PageURL    string // link to a page of results, minus the page number
+
+	SearchParams string // the parameters of the search shown, URL-encoded
+
+	Candidates    []FeedCandidate
+	Preview       *FeedPreview
+	ImportResults []OPMLImportResult
+
+	BiasRatings       []BiasRating
+	BiasImportResults []BiasImportResult
+	Balance           []BalanceColumn
+	Categories        []Category
+	Evaluations       []ClassifierEvaluation
+	TopEntities       []Entity
+	Timeline          []EntityMentions
+
+	SavedSearches []SavedSearch
+	SavedSearch   *SavedSearch // the saved search being viewed
+	Unread        UnreadCounts
+
+	Retention        []RetentionReport
+	DefaultRetention RetentionPolicy
+
+	APITokens []APIToken
+	NewToken  string // shown once, right after it is created
 }
 
 func safeHTML(content string) template.HTML {
@@ -32,6 +77,8 @@ func safeHTML(content string) template.HTML {
 		log.Printf("[ERROR] Content appears garbled, replacing with error message")
 		return template.HTML("<div class='error-message'><p>Sorry, we couldn't properly display this article.</p><p>The article might be behind a paywall or requires JavaScript.</p><p><a href='' class='text-blue-500'>Try viewing the original article</a></p></div>")
 	}
+	// Content is sanitized when it is stored; older rows were sanitized by
+	// a migration.
 	return template.HTML(content)
 }
 
@@ -48,31 +95,93 @@ func main() {
 	if err := initDB(db); err != nil {
 		log.Fatal("Failed to initialize database:", err)
 	}
+	if err := loadCategories(db); err != nil {
+		log.Fatal("Failed to load categories:", err)
+	}
+	if len(os.Args) > 1 {
+		if err := runCommand(db, os.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	tmpl := template.New("base").Funcs(template.FuncMap{
-		"safeHTML": safeHTML,
+		"safeHTML":   safeHTML,
+		"percent":    func(f float64) float64 { return f * 100 },
+		"pathEscape": url.PathEscape,
+		"add":        func(a, b int) int { return a + b },
+		"age":        formatRetentionAge,
 	})
 	templates = template.Must(tmpl.ParseFiles(
 		"templates/base.html",
+		"templates/sidebar.html",
 		"templates/index.html",
 		"templates/article.html",
 		"templates/feeds.html",
 		"templates/login.html",
 		"templates/register.html",
+		"templates/balance.html",
+		"templates/sources.html",
+		"templates/classifier.html",
+		"templates/categories.html",
+		"templates/entity.html",
+		"templates/retention.html",
+		"templates/tokens.html",
 	))
 	log.Printf("Defined templates after loading: %v", templates.DefinedTemplates())
 	if t := templates.Lookup("base"); t == nil {
 		log.Fatal("base template not found")
 	}
+	if err := trainCategoryModel(db); err != nil {
+		log.Printf("[ERROR] Error training category classifier: %v", err)
+	}
 	go runBackgroundTasks()
+	runContentPrefetcher(db)
 	http.HandleFunc("/", requireLogin(homeHandler))
 	http.HandleFunc("/article/", requireLogin(articleHandler))
+	http.HandleFunc("/article/extract/", requireLogin(reextractArticleHandler))
+	http.HandleFunc("/article/category/", requireLogin(categoryCorrectionHandler))
+	http.HandleFunc("/article/unread/", requireLogin(markUnreadHandler))
+	http.HandleFunc("/article/star/", requireLogin(starHandler))
+	http.HandleFunc("/starred", requireLogin(starredHandler))
+	http.HandleFunc("/articles/mark-read", requireLogin(markReadHandler))
 	http.HandleFunc("/feeds", requireLogin(feedsHandler))
 	http.HandleFunc("/feeds/add", requireLogin(addFeedHandler))
+	http.HandleFunc("/feeds/subscribe", requireLogin(subscribeFeedHandler))
 	http.HandleFunc("/feeds/delete/", requireLogin(deleteFeedHandler))
+	http.HandleFunc("/feeds/rename/", requireLogin(renameFeedHandler))
+	http.HandleFunc("/feeds/retry/", requireLogin(retryFeedHandler))
+	http.HandleFunc("/feeds/import", requireLogin(importOPMLHandler))
+	http.HandleFunc("/feeds/export.opml", requireLogin(exportOPMLHandler))
 	http.HandleFunc("/login", loginHandler)
 	http.HandleFunc("/register", registerHandler)
 	http.HandleFunc("/logout", logoutHandler)
+	http.HandleFunc("/logout/all", requireLogin(logoutAllHandler))
 	http.HandleFunc("/search", requireLogin(searchHandler))
+	http.HandleFunc("/searches/", requireLogin(savedSearchHandler))
+	http.HandleFunc("/searches/save", requireLogin(saveSearchHandler))
+	http.HandleFunc("/searches/delete/", requireLogin(deleteSavedSearchHandler))
+	http.HandleFunc("/balance", requireLogin(balanceHandler))
+	http.HandleFunc("/entity/", requireLogin(entityHandler))
+	http.HandleFunc("/tokens", requireLogin(tokensHandler))
+	http.HandleFunc("/tokens/create", requireLogin(createTokenHandler))
+	http.HandleFunc("/tokens/delete/", requireLogin(deleteTokenHandler))
+	http.HandleFunc("/admin/sources", requireAdmin(adminSourcesHandler))
+	http.HandleFunc("/admin/sources/rating", requireAdmin(saveBiasRatingHandler))
+	http.HandleFunc("/admin/sources/feed/", requireAdmin(feedBiasHandler))
+	http.HandleFunc("/admin/sources/import", requireAdmin(importBiasCSVHandler))
+	http.HandleFunc("/admin/classifier", requireAdmin(classifierReportHandler))
+	http.HandleFunc("/admin/categories", requireAdmin(adminCategoriesHandler))
+	http.HandleFunc("/admin/categories/save", requireAdmin(saveCategoryHandler))
+	http.HandleFunc("/admin/categories/delete/", requireAdmin(deleteCategoryHandler))
+	http.HandleFunc("/admin/retention", requireAdmin(retentionHandler))
+	http.HandleFunc("/admin/retention/feed/", requireAdmin(feedRetentionHandler))
+	http.HandleFunc(apiPrefix+"/openapi.json", apiSpecHandler)
+	http.HandleFunc(apiPrefix+"/feeds", requireToken(scopeRead, apiFeedsHandler))
+	http.HandleFunc(apiPrefix+"/feeds/", requireToken(scopeRead, apiFeedHandler))
+	http.HandleFunc(apiPrefix+"/articles", requireToken(scopeRead, apiArticlesHandler))
+	http.HandleFunc(apiPrefix+"/articles/", requireToken(scopeRead, apiArticleHandler))
+	http.HandleFunc(apiPrefix+"/search", requireToken(scopeRead, apiSearchHandler))
+	http.HandleFunc(apiPrefix+"/admin/retention", requireToken(scopeAdmin, apiRetentionHandler))
 	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
 	log.Println("Server starting on :8080")
 	if err := http.ListenAndServe(":8080", nil); err != nil {
@@ -89,14 +198,24 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Get all query parameters at once
 	queryParams := r.URL.Query()
-	feedID := queryParams.Get("feed")
-	category := queryParams.Get("category")
+	filter := ArticleFilter{
+		FeedID:   queryParams.Get("feed"),
+		Category: queryParams.Get("category"),
+	}
+	if sentiment := queryParams.Get("sentiment"); containsString(sentimentLabels, sentiment) {
+		filter.Sentiment = sentiment
+	}
+	if bias := queryParams.Get("bias"); bias == biasUnrated || containsString(biasLabels, bias) {
+		filter.Bias = bias
+	}
+	filter.UnreadOnly = queryParams.Get("unread") == "1"
 
 	// Debug logging to help trace the category parameter
-	log.Printf("[DEBUG] homeHandler called with feedID: '%s', category: '%s'", feedID, category)
+	log.Printf("[DEBUG] homeHandler called with filter: %+v", filter)
 
 	// Use a single function to get filtered articles
-	articles, err := getFilteredArticles(db, feedID, category)
+	user := currentUser(r)
+	articles, err := getFilteredArticles(db, user.ID, filter)
 	if err != nil {
 		log.Printf("[ERROR] Error getting articles: %v", err)
 		http.Error(w, "Failed to load articles", http.StatusInternalServerError)
@@ -104,6 +223,14 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	log.Printf("[DEBUG] Retrieved %d articles", len(articles))
+	articles = groupStories(articles)
+	if err := attachArticleEntities(db, articles); err != nil {
+		log.Printf("[ERROR] Error getting article entities: %v", err)
+	}
+	topEntities, err := getTopEntities(db, user.ID, time.Now().Add(-24*time.Hour), 12)
+	if err != nil {
+		log.Printf("[ERROR] Error getting top entities: %v", err)
+	}
 
 	// If articles were found, sample a few to check categories
 	if len(articles) > 0 {
@@ -115,18 +242,20 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	feeds, err := getFeeds(db)
+	feeds, err := getFeeds(db, user.ID)
 	if err != nil {
 		log.Printf("[ERROR] Error getting feeds: %v", err)
 		http.Error(w, "Failed to load feeds", http.StatusInternalServerError)
 		return
 	}
 
-	renderTemplate(w, "index.html", PageData{
-		Articles: articles,
-		Feeds:    feeds,
-		Active:   "home",
-		Query:    category, // Pass the category to the template
+	renderTemplate(w, r, "index.html", PageData{
+		Articles:    articles,
+		Feeds:       feeds,
+		Active:      "home",
+		Query:       filter.Category, // Pass the category to the template
+		Filters:     filter,
+		TopEntities: topEntities,
 	})
 }
 
@@ -134,7 +263,7 @@ func articleHandler(w http.ResponseWriter, r *http.Request) {
 	id := r.URL.Path[len("/article/"):]
 	log.Printf("[DEBUG] articleHandler: Fetching article with ID: %s", id)
 
-	article, err := getArticleByID(db, id)
+	article, err := getArticleByID(db, currentUser(r).ID, id)
 	if err != nil {
 		log.Printf("[ERROR] Article not found: %v", err)
 		http.Error(w, "Article not found", http.StatusNotFound)
@@ -142,20 +271,35 @@ func articleHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	log.Printf("[DEBUG] Retrieved article: %s, URL: %s", article.Title, article.URL)
+	if err := setArticleRead(db, currentUser(r).ID, article.ID, true); err != nil {
+		log.Printf("[ERROR] Error marking article read: %v", err)
+	}
 	log.Printf("[DEBUG] Initial summary length: %d", len(article.Summary))
+	if article.Entities, err = getArticleEntities(db, article.ID); err != nil {
+		log.Printf("[ERROR] Error getting article entities: %v", err)
+	}
 
-	// Fetch and parse full content always for better results
-	log.Printf("[DEBUG] Fetching full content from URL: %s", article.URL)
-	content := fetchArticleContent(article.URL)
+	// Extract the full content the first time the article is opened unless
+	// the prefetcher already did
+	if article.ContentStatus == "" {
+		log.Printf("[DEBUG] Fetching full content from URL: %s", article.URL)
+		extracted, err := extractAndStoreContent(db, article.ID, article.URL)
+		if err != nil {
+			log.Printf("[ERROR] Error storing extracted content: %v", err)
+		}
+		article.Content = extracted.Content
+		article.ContentMethod = extracted.ContentMethod
+		article.ContentStatus = extracted.ContentStatus
+		article.ContentExtractedAt = extracted.ContentExtractedAt
+	}
 
-	if content != "No content available" && !isBinaryOrGarbled(content) {
-		article.Summary = content
-		log.Printf("[DEBUG] Updated article summary with fresh content")
+	if article.ContentStatus == contentStatusOK && !isBinaryOrGarbled(article.Content) {
+		article.Summary = article.Content
+		log.Printf("[DEBUG] Using stored %s content", article.ContentMethod)
 	} else if isBinaryOrGarbled(article.Summary) {
 		// If current summary is also garbled, use a fallback message
 		log.Printf("[WARN] Both fetched content and existing summary are garbled")
-		article.Summary = "<p>Content couldn't be properly displayed. <a href='" +
-			article.URL + "' target='_blank' class='text-blue-500'>View the original article</a>.</p>"
+		article.Summary = unreadableNotice(article.URL)
 	}
 
 	// Log preview of actual content being sent to template
@@ -166,8 +310,10 @@ func articleHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	data := PageData{
-		Article: article,
-		Active:  "article",
+		Article:    article,
+		Active:     "article",
+		User:       currentUser(r),
+		Categories: currentCategories(),
 	}
 
 	log.Printf("[DEBUG] Rendering article %s", id)
@@ -181,43 +327,168 @@ func articleHandler(w http.ResponseWriter, r *http.Request) {
 	log.Printf("[DEBUG] Successfully rendered article %s", id)
 }
 
+// reextractArticleHandler discards the stored content of an article and
+// extracts it again from the original page.
+func reextractArticleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.URL.Path[len("/article/extract/"):]
+	article, err := getArticleByID(db, currentUser(r).ID, id)
+	if err != nil {
+		http.Error(w, "Article not found", http.StatusNotFound)
+		return
+	}
+	if _, err := extractAndStoreContent(db, article.ID, article.URL); err != nil {
+		log.Printf("[ERROR] Error storing extracted content: %v", err)
+		http.Error(w, "Failed to extract article", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/article/"+id, http.StatusSeeOther)
+}
+
 func feedsHandler(w http.ResponseWriter, r *http.Request) {
-	feeds, err := getFeeds(db)
+	data, err := loadFeedsPage(r)
 	if err != nil {
 		http.Error(w, "Failed to load feeds: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-	data := PageData{
+	renderTemplate(w, r, "feeds.html", data)
+}
+
+// loadFeedsPage builds the /feeds page data for the current user, honoring
+// the ?status=broken filter.
+func loadFeedsPage(r *http.Request) (PageData, error) {
+	feeds, err := getFeeds(db, currentUser(r).ID)
+	if err != nil {
+		return PageData{}, err
+	}
+	filter := r.URL.Query().Get("status")
+	var broken []Feed
+	for _, f := range feeds {
+		if !f.Broken() {
+			continue
+		}
+		f.RecentErrors, err = getFeedErrors(db, f.ID, 5)
+		if err != nil {
+			log.Printf("Error loading feed errors for %d: %v", f.ID, err)
+		}
+		broken = append(broken, f)
+	}
+	if filter == "broken" {
+		feeds = broken
+	} else {
+		filter = ""
+	}
+	return PageData{
 		Feeds:  feeds,
 		Active: "feeds",
+		Filter: filter,
+		Count:  len(broken),
+	}, nil
+}
+
+func addFeedHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
-	w.Header().Set("Content-Type", "text/html")
-	if err := templates.ExecuteTemplate(w, "feeds.html", data); err != nil {
-		log.Printf("Template execution error: %v", err)
-		http.Error(w, "Template error", http.StatusInternalServerError)
+	name := strings.TrimSpace(r.FormValue("name"))
+	url := strings.TrimSpace(r.FormValue("url"))
+	if url == "" {
+		http.Error(w, "URL is required", http.StatusBadRequest)
+		return
+	}
+
+	candidates, err := discoverFeeds(url)
+	if err != nil {
+		log.Printf("Error discovering feeds at %s: %v", url, err)
+		http.Error(w, "Could not load "+url+": "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	if len(candidates) == 0 {
+		http.Error(w, "No RSS, Atom or JSON feed found at "+url, http.StatusUnprocessableEntity)
+		return
+	}
+	if len(candidates) > 1 {
+		// Let the user pick which of the site's feeds to subscribe to.
+		data, err := loadFeedsPage(r)
+		if err != nil {
+			http.Error(w, "Failed to load feeds: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		data.Candidates = candidates
+		data.Query = name
+		renderTemplate(w, r, "feeds.html", data)
+		return
+	}
+
+	preview, err := previewFeed(candidates[0].URL)
+	if err != nil {
+		http.Error(w, "Invalid feed: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	subscribed, err := isSubscribedToURL(db, currentUser(r).ID, preview.URL)
+	if err != nil {
+		http.Error(w, "Failed to add feed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if subscribed {
+		http.Error(w, "You are already subscribed to this feed", http.StatusConflict)
+		return
+	}
+	data, err := loadFeedsPage(r)
+	if err != nil {
+		http.Error(w, "Failed to load feeds: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if name == "" {
+		name = preview.Title
+	}
+	rememberPreview(currentUser(r).ID, preview)
+	data.Preview = preview
+	data.Query = name
+	renderTemplate(w, r, "feeds.html", data)
 }
 
-func addFeedHandler(w http.ResponseWriter, r *http.Request) {
+// subscribeFeedHandler subscribes the user to a previewed feed, validating
+// it first unless it was previewed moments ago, and queues its first fetch.
+func subscribeFeedHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	name := r.FormValue("name")
-	url := r.FormValue("url")
-	if name == "" || url == "" {
-		http.Error(w, "Name and URL are required", http.StatusBadRequest)
+	name := strings.TrimSpace(r.FormValue("name"))
+	url := strings.TrimSpace(r.FormValue("url"))
+	if url == "" {
+		http.Error(w, "URL is required", http.StatusBadRequest)
 		return
 	}
-	if err := addFeed(db, name, url); err != nil {
+	preview, ok := takePreview(currentUser(r).ID, url)
+	if !ok {
+		var err error
+		if preview, err = previewFeed(url); err != nil {
+			http.Error(w, "Invalid feed: "+err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+	if name == "" {
+		name = preview.Title
+	}
+	if name == "" {
+		name = feedHost(preview.URL)
+	}
+	feedID, err := addFeed(db, currentUser(r).ID, name, preview.URL, strings.TrimSpace(r.FormValue("folder")))
+	if err != nil {
+		if err == errAlreadySubscribed {
+			http.Error(w, "You are already subscribed to this feed", http.StatusConflict)
+			return
+		}
 		http.Error(w, "Failed to add feed: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-	// Parse the newly added feed immediately
-	if err := parseRSSFeeds(db); err != nil {
-		log.Printf("Error parsing newly added feed: %v", err)
-	}
+	refreshFeedInBackground(db, feedID)
 	http.Redirect(w, r, "/feeds", http.StatusSeeOther)
 }
 
@@ -227,44 +498,174 @@ func deleteFeedHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	id := r.URL.Path[len("/feeds/delete/"):]
-	if err := deleteFeed(db, id); err != nil {
+	if err := unsubscribeFeed(db, currentUser(r).ID, id); err != nil {
 		http.Error(w, "Failed to delete feed: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 	http.Redirect(w, r, "/feeds", http.StatusSeeOther)
 }
 
+func renameFeedHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.URL.Path[len("/feeds/rename/"):]
+	title := strings.TrimSpace(r.FormValue("title"))
+	folder := strings.TrimSpace(r.FormValue("folder"))
+	if err := updateSubscription(db, currentUser(r).ID, id, title, folder); err != nil {
+		if err == sql.ErrNoRows {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "Failed to rename feed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/feeds", http.StatusSeeOther)
+}
+
+// retryFeedHandler re-enables a broken feed and queues it for the next
+// scheduler tick.
+func retryFeedHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.URL.Path[len("/feeds/retry/"):]
+	if err := resetFeedHealth(db, currentUser(r).ID, id); err != nil {
+		if err == sql.ErrNoRows {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "Failed to retry feed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/feeds?status=broken", http.StatusSeeOther)
+}
+
 func searchHandler(w http.ResponseWriter, r *http.Request) {
-	query := r.URL.Query().Get("q")
-	if query == "" {
+	params := r.URL.Query()
+	query := params.Get("q")
+	text, filter := searchFromParams(params)
+	if text == "" && filter == (ArticleFilter{}) {
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
+	page, err := strconv.Atoi(params.Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	params.Del("page")
 
-	articles, err := searchArticles(db, query)
+	user := currentUser(r)
+	articles, total, err := searchArticles(db, user.ID, text, filter, searchByRelevance, searchPageSize, (page-1)*searchPageSize)
 	if err != nil {
 		log.Printf("Search error: %v", err)
 		http.Error(w, "Failed to search articles", http.StatusInternalServerError)
 		return
 	}
+	if err := attachArticleEntities(db, articles); err != nil {
+		log.Printf("Error getting article entities: %v", err)
+	}
 
-	feeds, err := getFeeds(db)
+	feeds, err := getFeeds(db, user.ID)
 	if err != nil {
 		log.Printf("Error getting feeds: %v", err)
 		http.Error(w, "Failed to load feeds", http.StatusInternalServerError)
 		return
 	}
 
-	renderTemplate(w, "index.html", PageData{
-		Articles: articles,
-		Feeds:    feeds,
-		Active:   "search",
-		Query:    query,
+	renderTemplate(w, r, "index.html", PageData{
+		Articles:     articles,
+		Feeds:        feeds,
+		Active:       "search",
+		Query:        query,
+		Count:        total,
+		Filters:      filter,
+		Page:         page,
+		TotalPages:   (total + searchPageSize - 1) / searchPageSize,
+		SearchParams: params.Encode(),
+		PageURL:      "/search?" + params.Encode() + "&page=",
 	})
 }
 
-// Helper function to render templates with proper error handling
-func renderTemplate(w http.ResponseWriter, tmpl string, data interface{}) {
+// sidebarTTL bounds how stale cached sidebar counts get when a change is
+// not invalidated explicitly.
+const sidebarTTL = time.Minute
+
+type sidebarCounts struct {
+	searches []SavedSearch
+	unread   UnreadCounts
+	expires  time.Time
+}
+
+// sidebars caches the saved search and unread counts every page shows,
+// which take a query per saved search to compute. Entries are dropped when
+// articles are stored or deleted and when a user reads articles or changes
+// their feeds or searches; generation keeps a count computed during such a
+// change from being cached.
+var sidebars = struct {
+	sync.Mutex
+	users      map[int]sidebarCounts
+	generation int
+}{users: make(map[int]sidebarCounts)}
+
+func loadSidebar(userID int) ([]SavedSearch, UnreadCounts, error) {
+	sidebars.Lock()
+	cached, ok := sidebars.users[userID]
+	generation := sidebars.generation
+	sidebars.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.searches, cached.unread, nil
+	}
+
+	searches, err := getSavedSearches(db, userID)
+	if err != nil {
+		return nil, UnreadCounts{}, fmt.Errorf("saved searches: %v", err)
+	}
+	unread, err := getUnreadCounts(db, userID)
+	if err != nil {
+		return nil, UnreadCounts{}, fmt.Errorf("unread counts: %v", err)
+	}
+	sidebars.Lock()
+	if sidebars.generation == generation {
+		sidebars.users[userID] = sidebarCounts{searches: searches, unread: unread, expires: time.Now().Add(sidebarTTL)}
+	}
+	sidebars.Unlock()
+	return searches, unread, nil
+}
+
+// forgetSidebar drops the user's cached sidebar counts.
+func forgetSidebar(userID int) {
+	sidebars.Lock()
+	delete(sidebars.users, userID)
+	sidebars.generation++
+	sidebars.Unlock()
+}
+
+// forgetAllSidebars drops every user's cached sidebar counts.
+func forgetAllSidebars() {
+	sidebars.Lock()
+	clear(sidebars.users)
+	sidebars.generation++
+	sidebars.Unlock()
+}
+
+// renderTemplate renders a page for the logged-in user, who is made
+// available to the template as .User. Pages get the category taxonomy for
+// navigation unless they set their own, and the user's saved searches and
+// unread counts for the sidebar.
+func renderTemplate(w http.ResponseWriter, r *http.Request, tmpl string, data PageData) {
+	data.User = currentUser(r)
+	if data.Categories == nil {
+		data.Categories = currentCategories()
+	}
+	if data.User.ID != 0 {
+		var err error
+		if data.SavedSearches, data.Unread, err = loadSidebar(data.User.ID); err != nil {
+			log.Printf("[ERROR] Error getting sidebar counts: %v", err)
+		}
+	}
 	w.Header().Set("Content-Type", "text/html")
 	if err := templates.ExecuteTemplate(w, tmpl, data); err != nil {
 		log.Printf("Template execution error (%s): %v", tmpl, err)
@@ -285,7 +686,7 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	username := r.FormValue("username")
 	password := r.FormValue("password")
-	authenticated, err := authenticateUser(db, username, password)
+	user, authenticated, err := authenticateUser(db, username, password)
 	if err != nil {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
@@ -294,11 +695,13 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
-	http.SetCookie(w, &http.Cookie{
-		Name:  "session",
-		Value: username,
-		Path:  "/",
-	})
+	token, expiresAt, err := createSession(db, user.ID)
+	if err != nil {
+		log.Printf("Error creating session: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	setSessionCookie(w, r, token, expiresAt)
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
@@ -338,16 +741,83 @@ func requireLogin(next http.HandlerFunc) http.HandlerFunc {
 			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
-		next(w, r)
+		user, err := getSessionUser(db, cookie.Value)
+		if err != nil {
+			if err != sql.ErrNoRows {
+				log.Printf("Error looking up session: %v", err)
+			}
+			clearSessionCookie(w, r)
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+		ctx := context.WithValue(r.Context(), userContextKey, user)
+		next(w, r.WithContext(ctx))
 	}
 }
 
-func logoutHandler(w http.ResponseWriter, r *http.Request) {
+// requireAdmin is requireLogin for pages only admins may use.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return requireLogin(func(w http.ResponseWriter, r *http.Request) {
+		if !currentUser(r).IsAdmin {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// currentUser returns the user attached to the request by requireLogin.
+func currentUser(r *http.Request) User {
+	user, _ := r.Context().Value(userContextKey).(User)
+	return user
+}
+
+func setSessionCookie(w http.ResponseWriter, r *http.Request, token string, expiresAt time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session",
+		Value:    token,
+		Path:     "/",
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   isSecureRequest(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearSessionCookie(w http.ResponseWriter, r *http.Request) {
 	http.SetCookie(w, &http.Cookie{
-		Name:   "session",
-		Value:  "",
-		Path:   "/",
-		MaxAge: -1,
+		Name:     "session",
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   isSecureRequest(r),
+		SameSite: http.SameSiteLaxMode,
 	})
+}
+
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie("session"); err == nil && cookie.Value != "" {
+		if err := deleteSession(db, cookie.Value); err != nil {
+			log.Printf("Error deleting session: %v", err)
+		}
+	}
+	clearSessionCookie(w, r)
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// logoutAllHandler revokes every session of the current user, signing them
+// out on all devices.
+func logoutAllHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := deleteUserSessions(db, currentUser(r).ID); err != nil {
+		log.Printf("Error deleting sessions: %v", err)
+		http.Error(w, "Failed to log out devices", http.StatusInternalServerError)
+		return
+	}
+	clearSessionCookie(w, r)
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
 }
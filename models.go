@@ -2,16 +2,63 @@ package main
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
 	"log"
 	"strings"
 	"time"
 )
 
+type User struct {
+	ID        int
+	Username  string
+	CreatedAt time.Time
+	IsAdmin   bool
+}
+
 type Feed struct {
 	ID        int
 	Name      string
 	URL       string
+	Folder    string
 	CreatedAt time.Time
+
+	// Bias rating set by an admin for this feed; empty means the rating
+	// of the article's domain applies.
+	Bias string
+
+	// Retention settings set by an admin for this feed.
+	Retention FeedRetention
+
+	// HTTP caching state used for conditional GETs when polling the feed.
+	ETag         string
+	LastModified string
+	ContentHash  string
+
+	// Polling schedule, adapted after every fetch.
+	RefreshInterval time.Duration
+	NextCheckAt     time.Time
+
+	// Fetch health, updated after every poll.
+	LastSuccessAt       time.Time
+	LastError           string
+	LastStatus          int
+	ConsecutiveFailures int
+	Disabled            bool
+	RecentErrors        []FeedError
+}
+
+// FeedError is one entry in a feed's fetch error history.
+type FeedError struct {
+	Status     int
+	Message    string
+	OccurredAt time.Time
+}
+
+// Broken reports whether the feed's last poll failed or it was disabled.
+func (f Feed) Broken() bool {
+	return f.Disabled || f.ConsecutiveFailures > 0
 }
 
 type Article struct {
@@ -27,10 +74,55 @@ type Article struct {
 	Bias        string
 	ImageURL    string
 	CreatedAt   time.Time
+
+	// Lexicon score from -1 (negative) to 1 (positive) behind Sentiment.
+	SentimentScore float64
+
+	// People, organizations and places mentioned, loaded for display.
+	Entities []Entity
+
+	// Story cluster of near-duplicate copies of this article, and the other
+	// copies when the home page groups them under one card.
+	StoryID int
+	Related []Article
+
+	// Highlighted text around the matches, for search results.
+	Snippet template.HTML
+	// Fetched since the user last opened the saved search listing it.
+	New bool
+	// Opened or marked as read by the user.
+	Read bool
+	// Starred by the user, which keeps it from being cleaned up.
+	Starred bool
+
+	// Full text extracted from the article page, filled in once.
+	Content            string
+	ContentExtractedAt time.Time
+	ContentMethod      string
+	ContentStatus      string
 }
 
 func initDB(db *sql.DB) error {
-	_, err := db.Exec(`
+	if err := checkSearchSupport(db); err != nil {
+		return err
+	}
+	hadSubscriptions, err := tableExists(db, "subscriptions")
+	if err != nil {
+		return err
+	}
+	hadBiasRatings, err := tableExists(db, "bias_ratings")
+	if err != nil {
+		return err
+	}
+	hadCategories, err := tableExists(db, "categories")
+	if err != nil {
+		return err
+	}
+	hadSearchIndex, err := tableExists(db, "articles_fts")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`
 		CREATE TABLE IF NOT EXISTS users (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			username TEXT NOT NULL UNIQUE,
@@ -52,42 +144,498 @@ func initDB(db *sql.DB) error {
 			published_at DATETIME,
 			category TEXT DEFAULT 'other',
 			sentiment TEXT DEFAULT 'neutral',
-			bias TEXT DEFAULT 'neutral',
+			bias TEXT DEFAULT 'unrated',
 			image_url TEXT,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			FOREIGN KEY (feed_id) REFERENCES feeds(id)
 		);
+		CREATE TABLE IF NOT EXISTS sessions (
+			token_hash TEXT PRIMARY KEY,
+			user_id INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			expires_at DATETIME NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		);
+		CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
+		CREATE TABLE IF NOT EXISTS subscriptions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			feed_id INTEGER NOT NULL,
+			title TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (user_id, feed_id),
+			FOREIGN KEY (user_id) REFERENCES users(id),
+			FOREIGN KEY (feed_id) REFERENCES feeds(id)
+		);
+		CREATE INDEX IF NOT EXISTS idx_subscriptions_feed_id ON subscriptions(feed_id);
+		CREATE TABLE IF NOT EXISTS feed_errors (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			feed_id INTEGER NOT NULL,
+			status INTEGER,
+			message TEXT NOT NULL,
+			occurred_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (feed_id) REFERENCES feeds(id)
+		);
+		CREATE INDEX IF NOT EXISTS idx_feed_errors_feed_id ON feed_errors(feed_id);
+		CREATE TABLE IF NOT EXISTS categories (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			slug TEXT NOT NULL UNIQUE,
+			position INTEGER NOT NULL DEFAULT 0,
+			keywords TEXT,
+			aliases TEXT
+		);
+		CREATE TABLE IF NOT EXISTS category_examples (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			article_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			text TEXT NOT NULL,
+			category TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (article_id, user_id)
+		);
+		CREATE TABLE IF NOT EXISTS entities (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			type TEXT NOT NULL,
+			UNIQUE (name, type)
+		);
+		CREATE TABLE IF NOT EXISTS article_entities (
+			article_id INTEGER NOT NULL,
+			entity_id INTEGER NOT NULL,
+			PRIMARY KEY (article_id, entity_id),
+			FOREIGN KEY (article_id) REFERENCES articles(id),
+			FOREIGN KEY (entity_id) REFERENCES entities(id)
+		);
+		CREATE INDEX IF NOT EXISTS idx_article_entities_entity_id ON article_entities(entity_id);
+		CREATE TABLE IF NOT EXISTS article_state (
+			user_id INTEGER NOT NULL,
+			article_id INTEGER NOT NULL,
+			read INTEGER NOT NULL DEFAULT 0,
+			read_at DATETIME,
+			PRIMARY KEY (user_id, article_id),
+			FOREIGN KEY (user_id) REFERENCES users(id),
+			FOREIGN KEY (article_id) REFERENCES articles(id)
+		);
+		CREATE INDEX IF NOT EXISTS idx_article_state_article_id ON article_state(article_id);
+		CREATE TABLE IF NOT EXISTS saved_searches (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			params TEXT NOT NULL,
+			last_viewed_at DATETIME NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (user_id, name),
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		);
+		CREATE TABLE IF NOT EXISTS api_tokens (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			token_hash TEXT NOT NULL UNIQUE,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		);
+		CREATE TABLE IF NOT EXISTS bias_ratings (
+			domain TEXT PRIMARY KEY,
+			rating TEXT NOT NULL,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
 	`)
+	if err != nil {
+		return err
+	}
+	feedColumns := []struct{ name, definition string }{
+		{"etag", "TEXT"},
+		{"last_modified", "TEXT"},
+		{"content_hash", "TEXT"},
+		{"refresh_interval", "INTEGER"},
+		{"next_check_at", "DATETIME"},
+		{"last_success_at", "DATETIME"},
+		{"last_error", "TEXT"},
+		{"last_status", "INTEGER"},
+		{"consecutive_failures", "INTEGER DEFAULT 0"},
+		{"disabled", "INTEGER DEFAULT 0"},
+		{"canonical_url", "TEXT"},
+		{"bias", "TEXT"},
+		{"ingest_max_age", "INTEGER"},
+		{"retention_max_age", "INTEGER"},
+		{"retention_max_items", "INTEGER"},
+	}
+	for _, c := range feedColumns {
+		if err := addColumnIfMissing(db, "feeds", c.name, c.definition); err != nil {
+			return err
+		}
+	}
+	if err := addColumnIfMissing(db, "subscriptions", "folder", "TEXT"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "users", "is_admin", "INTEGER DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "article_state", "starred", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "article_state", "starred_at", "DATETIME"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "api_tokens", "scope", "TEXT NOT NULL DEFAULT 'read'"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "api_tokens", "last_used_at", "DATETIME"); err != nil {
+		return err
+	}
+	// Accounts created before roles existed: the oldest one administers.
+	if _, err := db.Exec(`
+		UPDATE users SET is_admin = 1
+		WHERE id = (SELECT MIN(id) FROM users)
+		  AND NOT EXISTS (SELECT 1 FROM users WHERE is_admin = 1)
+	`); err != nil {
+		return err
+	}
+	articleColumns := []struct{ name, definition string }{
+		{"content", "TEXT"},
+		{"content_extracted_at", "DATETIME"},
+		{"content_method", "TEXT"},
+		{"content_status", "TEXT"},
+		{"sentiment_score", "REAL DEFAULT 0"},
+		{"fingerprint", "INTEGER"},
+		{"story_id", "INTEGER"},
+	}
+	for _, c := range articleColumns {
+		if err := addColumnIfMissing(db, "articles", c.name, c.definition); err != nil {
+			return err
+		}
+	}
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS idx_feeds_canonical_url ON feeds(canonical_url)"); err != nil {
+		return err
+	}
+	if err := backfillCanonicalURLs(db); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS migrations (
+			name TEXT PRIMARY KEY,
+			applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)
+	`); err != nil {
+		return err
+	}
+	if err := createSearchIndex(db); err != nil {
+		return err
+	}
+	if !hadSearchIndex {
+		if _, err := rebuildSearchIndex(db); err != nil {
+			return err
+		}
+	}
+	if err := runMigration(db, "sanitize-stored-articles", sanitizeStoredArticles); err != nil {
+		return err
+	}
+	if err := runMigration(db, "utc-published-at", publishedAtToUTC); err != nil {
+		return err
+	}
+	if !hadCategories {
+		if err := seedCategories(db); err != nil {
+			return err
+		}
+	}
+	if !hadBiasRatings {
+		// Bias used to be hardcoded to "neutral", which said nothing about
+		// the source.
+		if _, err := db.Exec("UPDATE articles SET bias = ? WHERE bias = 'neutral'", biasUnrated); err != nil {
+			return err
+		}
+	}
+	if !hadSubscriptions {
+		// Feeds used to be global; keep every existing account subscribed to
+		// the feeds it could already see.
+		_, err = db.Exec("INSERT OR IGNORE INTO subscriptions (user_id, feed_id) SELECT u.id, f.id FROM users u, feeds f")
+	}
+	return err
+}
+
+// addColumnIfMissing adds a column to an existing table so databases created
+// by older versions pick up new fields.
+func addColumnIfMissing(db *sql.DB, table, column, definition string) error {
+	rows, err := db.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return err
+		}
+		if strings.EqualFold(name, column) {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	_, err = db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
+
+func backfillCanonicalURLs(db *sql.DB) error {
+	rows, err := db.Query("SELECT id, url FROM feeds WHERE canonical_url IS NULL")
+	if err != nil {
+		return err
+	}
+	canonical := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var url string
+		if err := rows.Scan(&id, &url); err != nil {
+			rows.Close()
+			return err
+		}
+		canonical[id] = canonicalFeedURL(url)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for id, c := range canonical {
+		if _, err := db.Exec("UPDATE feeds SET canonical_url = ? WHERE id = ?", c, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runMigration applies a one-time data migration unless it is recorded as
+// done.
+func runMigration(db *sql.DB, name string, migrate func(*sql.DB) error) error {
+	var done int
+	if err := db.QueryRow("SELECT COUNT(*) FROM migrations WHERE name = ?", name).Scan(&done); err != nil {
+		return err
+	}
+	if done > 0 {
+		return nil
+	}
+	if err := migrate(db); err != nil {
+		return fmt.Errorf("migration %s: %v", name, err)
+	}
+	_, err := db.Exec("INSERT INTO migrations (name) VALUES (?)", name)
 	return err
 }
 
+// sanitizeStoredArticles sanitizes the summaries and extracted content
+// stored before sanitizing at ingest, so pages can render them as they are.
+func sanitizeStoredArticles(db *sql.DB) error {
+	rows, err := db.Query("SELECT id, url, IFNULL(summary, ''), IFNULL(content, '') FROM articles")
+	if err != nil {
+		return err
+	}
+	type storedArticle struct {
+		id               int
+		summary, content string
+	}
+	var changed []storedArticle
+	for rows.Next() {
+		var a storedArticle
+		var url string
+		if err := rows.Scan(&a.id, &url, &a.summary, &a.content); err != nil {
+			rows.Close()
+			return err
+		}
+		summary, content := sanitizeHTML(a.summary, url), sanitizeHTML(a.content, url)
+		if summary != a.summary || content != a.content {
+			changed = append(changed, storedArticle{a.id, summary, content})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, a := range changed {
+		if _, err := db.Exec("UPDATE articles SET summary = ?, content = NULLIF(?, '') WHERE id = ?", a.summary, a.content, a.id); err != nil {
+			return err
+		}
+		if err := indexArticle(db, a.id); err != nil {
+			return err
+		}
+	}
+	if len(changed) > 0 {
+		log.Printf("Sanitized %d stored articles", len(changed))
+	}
+	return nil
+}
+
+// publishedAtToUTC rewrites publication dates stored in their feed's time
+// zone as UTC, since filters compare them as text against UTC bounds.
+func publishedAtToUTC(db *sql.DB) error {
+	rows, err := db.Query("SELECT id, published_at FROM articles WHERE published_at IS NOT NULL")
+	if err != nil {
+		return err
+	}
+	dates := make(map[int]time.Time)
+	for rows.Next() {
+		var id int
+		var publishedAt time.Time
+		if err := rows.Scan(&id, &publishedAt); err != nil {
+			rows.Close()
+			return err
+		}
+		dates[id] = publishedAt
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for id, publishedAt := range dates {
+		if _, err := tx.Exec("UPDATE articles SET published_at = ? WHERE id = ?", publishedAt.UTC(), id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func tableExists(db *sql.DB, name string) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&count)
+	return count > 0, err
+}
+
 func createUser(db *sql.DB, username, password string) error {
 	hashedPassword, err := hashPassword(password)
 	if err != nil {
 		log.Printf("Error hashing password: %v", err)
 		return err
 	}
-	_, err = db.Exec("INSERT INTO users (username, password) VALUES (?, ?)", username, hashedPassword)
+	// The first account on a fresh install becomes the admin.
+	_, err = db.Exec(`
+		INSERT INTO users (username, password, is_admin)
+		VALUES (?, ?, NOT EXISTS (SELECT 1 FROM users WHERE is_admin = 1))
+	`, username, hashedPassword)
 	if err != nil {
 		log.Printf("Error inserting user into database: %v", err)
 	}
 	return err
 }
 
-func authenticateUser(db *sql.DB, username, password string) (bool, error) {
+func authenticateUser(db *sql.DB, username, password string) (User, bool, error) {
+	var u User
 	var hashedPassword string
-	err := db.QueryRow("SELECT password FROM users WHERE username = ?", username).Scan(&hashedPassword)
+	err := db.QueryRow("SELECT id, username, password, created_at, IFNULL(is_admin, 0) FROM users WHERE username = ?", username).
+		Scan(&u.ID, &u.Username, &hashedPassword, &u.CreatedAt, &u.IsAdmin)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return false, nil
+			return User{}, false, nil
 		}
-		return false, err
+		return User{}, false, err
+	}
+	if !checkPasswordHash(password, hashedPassword) {
+		return User{}, false, nil
 	}
-	return checkPasswordHash(password, hashedPassword), nil
+	return u, true, nil
 }
 
-func getFeeds(db *sql.DB) ([]Feed, error) {
-	rows, err := db.Query("SELECT id, name, url, created_at FROM feeds")
+// createSession stores a new session for the user and returns the raw token
+// for the cookie. Only a hash of the token is kept in the database.
+func createSession(db *sql.DB, userID int) (string, time.Time, error) {
+	token, err := generateToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expiresAt := time.Now().Add(sessionDuration)
+	_, err = db.Exec("INSERT INTO sessions (token_hash, user_id, expires_at) VALUES (?, ?, ?)",
+		hashToken(token), userID, expiresAt)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// getSessionUser returns the user owning an unexpired session token.
+func getSessionUser(db *sql.DB, token string) (User, error) {
+	var u User
+	err := db.QueryRow(`
+		SELECT u.id, u.username, u.created_at, IFNULL(u.is_admin, 0)
+		FROM sessions s
+		JOIN users u ON s.user_id = u.id
+		WHERE s.token_hash = ? AND s.expires_at > ?
+	`, hashToken(token), time.Now()).Scan(&u.ID, &u.Username, &u.CreatedAt, &u.IsAdmin)
+	return u, err
+}
+
+func deleteSession(db *sql.DB, token string) error {
+	_, err := db.Exec("DELETE FROM sessions WHERE token_hash = ?", hashToken(token))
+	return err
+}
+
+func deleteUserSessions(db *sql.DB, userID int) error {
+	_, err := db.Exec("DELETE FROM sessions WHERE user_id = ?", userID)
+	return err
+}
+
+func cleanupExpiredSessions(db *sql.DB) error {
+	_, err := db.Exec("DELETE FROM sessions WHERE expires_at <= ?", time.Now())
+	return err
+}
+
+// getFeeds returns the feeds the user is subscribed to, using the user's own
+// title for a feed when one is set.
+func getFeeds(db *sql.DB, userID int) ([]Feed, error) {
+	rows, err := db.Query(`
+		SELECT f.id, COALESCE(NULLIF(s.title, ''), f.name), f.url, IFNULL(s.folder, ''), s.created_at,
+		       f.next_check_at, f.last_success_at, IFNULL(f.last_error, ''), IFNULL(f.last_status, 0),
+		       IFNULL(f.consecutive_failures, 0), IFNULL(f.disabled, 0)
+		FROM subscriptions s
+		JOIN feeds f ON s.feed_id = f.id
+		WHERE s.user_id = ?
+		ORDER BY IFNULL(s.folder, '') COLLATE NOCASE, COALESCE(NULLIF(s.title, ''), f.name) COLLATE NOCASE
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanFeeds(rows)
+}
+
+// getAllFeeds returns every feed with at least one subscriber, for fetching.
+func getAllFeeds(db *sql.DB) ([]Feed, error) {
+	return queryFetchFeeds(db, "")
+}
+
+func getFeedForFetch(db *sql.DB, feedID int) (Feed, error) {
+	feeds, err := queryFetchFeeds(db, "AND f.id = ?", feedID)
+	if err != nil {
+		return Feed{}, err
+	}
+	if len(feeds) == 0 {
+		return Feed{}, sql.ErrNoRows
+	}
+	return feeds[0], nil
+}
+
+// getDueFeeds returns the subscribed feeds whose next check time has passed.
+func getDueFeeds(db *sql.DB, now time.Time) ([]Feed, error) {
+	return queryFetchFeeds(db, "AND (f.next_check_at IS NULL OR f.next_check_at <= ?)", now.UTC())
+}
+
+func queryFetchFeeds(db *sql.DB, condition string, args ...interface{}) ([]Feed, error) {
+	rows, err := db.Query(`
+		SELECT f.id, f.name, f.url, f.created_at,
+		       IFNULL(f.etag, ''), IFNULL(f.last_modified, ''), IFNULL(f.content_hash, ''),
+		       IFNULL(f.refresh_interval, 0), f.next_check_at,
+		       IFNULL(f.consecutive_failures, 0), IFNULL(f.bias, ''),
+		       f.ingest_max_age, f.retention_max_age, f.retention_max_items
+		FROM feeds f
+		WHERE EXISTS (SELECT 1 FROM subscriptions s WHERE s.feed_id = f.id)
+		  AND IFNULL(f.disabled, 0) = 0
+		`+condition, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -95,48 +643,331 @@ func getFeeds(db *sql.DB) ([]Feed, error) {
 	var feeds []Feed
 	for rows.Next() {
 		var f Feed
-		if err := rows.Scan(&f.ID, &f.Name, &f.URL, &f.CreatedAt); err != nil {
+		var intervalSeconds int64
+		var nextCheck sql.NullTime
+		var ingestMaxAge, maxAge, maxItems sql.NullInt64
+		if err := rows.Scan(&f.ID, &f.Name, &f.URL, &f.CreatedAt,
+			&f.ETag, &f.LastModified, &f.ContentHash,
+			&intervalSeconds, &nextCheck, &f.ConsecutiveFailures, &f.Bias,
+			&ingestMaxAge, &maxAge, &maxItems); err != nil {
 			return nil, err
 		}
+		f.Retention = scanFeedRetention(ingestMaxAge, maxAge, maxItems)
+		f.RefreshInterval = time.Duration(intervalSeconds) * time.Second
+		f.NextCheckAt = nextCheck.Time
 		feeds = append(feeds, f)
 	}
-	return feeds, nil
+	return feeds, rows.Err()
+}
+
+func updateFeedSchedule(db *sql.DB, feedID int, interval time.Duration, next time.Time) error {
+	_, err := db.Exec("UPDATE feeds SET refresh_interval = ?, next_check_at = ? WHERE id = ?",
+		int64(interval/time.Second), next.UTC(), feedID)
+	return err
 }
 
-func addFeed(db *sql.DB, name, url string) error {
-	_, err := db.Exec("INSERT INTO feeds (name, url) VALUES (?, ?)", name, url)
+func recordFeedSuccess(db *sql.DB, feedID, status int) error {
+	_, err := db.Exec(`
+		UPDATE feeds
+		SET last_success_at = ?, last_status = ?, last_error = '', consecutive_failures = 0
+		WHERE id = ?
+	`, time.Now().UTC(), status, feedID)
 	return err
 }
 
-func deleteFeed(db *sql.DB, id string) error {
-	_, err := db.Exec("DELETE FROM feeds WHERE id = ?", id)
+// recordFeedFailure stores a failed poll in the feed's error history and
+// returns the updated consecutive failure count. The feed is disabled once
+// the count reaches maxFailures.
+func recordFeedFailure(db *sql.DB, feedID, status int, message string, maxFailures int) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`
+		UPDATE feeds
+		SET last_error = ?, last_status = ?, consecutive_failures = IFNULL(consecutive_failures, 0) + 1
+		WHERE id = ?
+	`, message, status, feedID); err != nil {
+		return 0, err
+	}
+	var failures int
+	if err := tx.QueryRow("SELECT consecutive_failures FROM feeds WHERE id = ?", feedID).Scan(&failures); err != nil {
+		return 0, err
+	}
+	if maxFailures > 0 && failures >= maxFailures {
+		if _, err := tx.Exec("UPDATE feeds SET disabled = 1 WHERE id = ?", feedID); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := tx.Exec("INSERT INTO feed_errors (feed_id, status, message, occurred_at) VALUES (?, ?, ?, ?)",
+		feedID, status, message, time.Now().UTC()); err != nil {
+		return 0, err
+	}
+	// Keep only the most recent history per feed.
+	if _, err := tx.Exec(`
+		DELETE FROM feed_errors
+		WHERE feed_id = ? AND id NOT IN (
+			SELECT id FROM feed_errors WHERE feed_id = ? ORDER BY id DESC LIMIT 20
+		)
+	`, feedID, feedID); err != nil {
+		return 0, err
+	}
+	return failures, tx.Commit()
+}
+
+func getFeedErrors(db *sql.DB, feedID, limit int) ([]FeedError, error) {
+	rows, err := db.Query(`
+		SELECT IFNULL(status, 0), message, occurred_at
+		FROM feed_errors
+		WHERE feed_id = ?
+		ORDER BY id DESC
+		LIMIT ?
+	`, feedID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var errs []FeedError
+	for rows.Next() {
+		var e FeedError
+		if err := rows.Scan(&e.Status, &e.Message, &e.OccurredAt); err != nil {
+			return nil, err
+		}
+		errs = append(errs, e)
+	}
+	return errs, rows.Err()
+}
+
+// resetFeedHealth re-enables a feed the user subscribes to and makes it due
+// for an immediate poll.
+func resetFeedHealth(db *sql.DB, userID int, feedID string) error {
+	res, err := db.Exec(`
+		UPDATE feeds
+		SET disabled = 0, consecutive_failures = 0, next_check_at = NULL
+		WHERE id = ? AND EXISTS (SELECT 1 FROM subscriptions s WHERE s.feed_id = feeds.id AND s.user_id = ?)
+	`, feedID, userID)
 	if err != nil {
 		return err
 	}
-	_, err = db.Exec("DELETE FROM articles WHERE feed_id = ?", id)
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func updateFeedCacheState(db *sql.DB, feedID int, etag, lastModified, contentHash string) error {
+	_, err := db.Exec("UPDATE feeds SET etag = ?, last_modified = ?, content_hash = ? WHERE id = ?",
+		etag, lastModified, contentHash, feedID)
 	return err
 }
 
-func getFilteredArticles(db *sql.DB, feedID, category string) ([]Article, error) {
-	var query string
-	var args []interface{}
-	baseQuery := `
-        SELECT a.id, a.title, a.summary, a.url, a.feed_id, f.name, 
-               a.published_at, a.category, a.sentiment, a.bias, 
-               IFNULL(a.image_url, ''), a.created_at
-        FROM articles a
-        JOIN feeds f ON a.feed_id = f.id
-    `
+func scanFeeds(rows *sql.Rows) ([]Feed, error) {
+	var feeds []Feed
+	for rows.Next() {
+		var f Feed
+		var nextCheck, lastSuccess sql.NullTime
+		if err := rows.Scan(&f.ID, &f.Name, &f.URL, &f.Folder, &f.CreatedAt,
+			&nextCheck, &lastSuccess, &f.LastError, &f.LastStatus,
+			&f.ConsecutiveFailures, &f.Disabled); err != nil {
+			return nil, err
+		}
+		f.NextCheckAt = nextCheck.Time
+		f.LastSuccessAt = lastSuccess.Time
+		feeds = append(feeds, f)
+	}
+	return feeds, rows.Err()
+}
+
+// errAlreadySubscribed is returned by addFeed when the user already follows
+// a feed with the same canonical URL.
+var errAlreadySubscribed = errors.New("already subscribed to this feed")
+
+// addFeed subscribes the user to the feed at url and returns the feed ID.
+// Feeds are matched by canonical URL, so a feed another user already
+// follows is reused rather than fetched twice.
+func addFeed(db *sql.DB, userID int, name, url, folder string) (int, error) {
+	defer forgetSidebar(userID)
+	canonical := canonicalFeedURL(url)
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var feedID int
+	err = tx.QueryRow("SELECT id FROM feeds WHERE canonical_url = ? OR url = ? ORDER BY id LIMIT 1", canonical, url).Scan(&feedID)
+	if err == sql.ErrNoRows {
+		res, err := tx.Exec("INSERT INTO feeds (name, url, canonical_url) VALUES (?, ?, ?)", name, url, canonical)
+		if err != nil {
+			return 0, err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return 0, err
+		}
+		feedID = int(id)
+	} else if err != nil {
+		return 0, err
+	}
+
+	var subscribed int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM subscriptions WHERE user_id = ? AND feed_id = ?", userID, feedID).Scan(&subscribed); err != nil {
+		return 0, err
+	}
+	if subscribed > 0 {
+		return 0, errAlreadySubscribed
+	}
+	if _, err := tx.Exec("INSERT INTO subscriptions (user_id, feed_id, title, folder) VALUES (?, ?, ?, ?)", userID, feedID, name, folder); err != nil {
+		return 0, err
+	}
+	return feedID, tx.Commit()
+}
+
+// isSubscribedToURL reports whether the user follows a feed with the same
+// canonical URL.
+func isSubscribedToURL(db *sql.DB, userID int, url string) (bool, error) {
+	var count int
+	err := db.QueryRow(`
+		SELECT COUNT(*)
+		FROM subscriptions s
+		JOIN feeds f ON s.feed_id = f.id
+		WHERE s.user_id = ? AND (f.canonical_url = ? OR f.url = ?)
+	`, userID, canonicalFeedURL(url), url).Scan(&count)
+	return count > 0, err
+}
+
+func updateSubscription(db *sql.DB, userID int, feedID, title, folder string) error {
+	defer forgetSidebar(userID)
+	res, err := db.Exec("UPDATE subscriptions SET title = ?, folder = ? WHERE user_id = ? AND feed_id = ?", title, folder, userID, feedID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// unsubscribeFeed removes the user's subscription. Once a feed has no
+// subscribers left, the feed and its articles are deleted.
+func unsubscribeFeed(db *sql.DB, userID int, feedID string) error {
+	defer forgetSidebar(userID)
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM subscriptions WHERE user_id = ? AND feed_id = ?", userID, feedID); err != nil {
+		return err
+	}
+	var remaining int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM subscriptions WHERE feed_id = ?", feedID).Scan(&remaining); err != nil {
+		return err
+	}
+	if remaining > 0 {
+		return tx.Commit()
+	}
+	// Nobody reads the feed any more, but starred articles stay on the
+	// starred page of whoever starred them, along with their feed.
+	if _, err := tx.Exec(`
+		DELETE FROM articles
+		WHERE feed_id = ?
+		  AND id NOT IN (SELECT article_id FROM article_state WHERE starred = 1)
+	`, feedID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM feed_errors WHERE feed_id = ?", feedID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		DELETE FROM feeds
+		WHERE id = ? AND NOT EXISTS (SELECT 1 FROM articles WHERE feed_id = ?)
+	`, feedID, feedID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ArticleFilter narrows an article list. Empty fields match everything.
+type ArticleFilter struct {
+	FeedID    string
+	FeedName  string // part of the feed's title, as typed in a search
+	Category  string
+	Sentiment string
+	Bias      string
+	After     time.Time // published on or after
+	Before    time.Time // published before
+	HasImage  bool
+
+	UnreadOnly  bool
+	StarredOnly bool
+
+	AddedAfter time.Time // fetched after, for new matches of saved searches
+}
+
+// conditions returns the SQL conditions selecting the filtered articles and
+// their arguments, for queries over articles a joined with their feed f and
+// the user's subscription s.
+func (filter ArticleFilter) conditions() ([]string, []interface{}) {
 	var conditions []string
-	if feedID != "" {
+	var args []interface{}
+	if filter.FeedID != "" {
 		conditions = append(conditions, "a.feed_id = ?")
-		args = append(args, feedID)
+		args = append(args, filter.FeedID)
+	}
+	if filter.FeedName != "" {
+		conditions = append(conditions, "COALESCE(NULLIF(s.title, ''), f.name) LIKE ?")
+		args = append(args, "%"+filter.FeedName+"%")
 	}
-	if category != "" && category != "all" {
-		log.Printf("[DEBUG] Filtering by category: '%s'", category)
+	if filter.Category != "" && filter.Category != "all" {
+		log.Printf("[DEBUG] Filtering by category: '%s'", filter.Category)
 		conditions = append(conditions, "LOWER(a.category) = LOWER(?)")
-		args = append(args, category)
+		args = append(args, filter.Category)
+	}
+	if filter.Sentiment != "" {
+		conditions = append(conditions, "a.sentiment = ?")
+		args = append(args, filter.Sentiment)
+	}
+	if filter.Bias != "" {
+		conditions = append(conditions, "a.bias = ?")
+		args = append(args, filter.Bias)
+	}
+	if !filter.After.IsZero() {
+		conditions = append(conditions, "a.published_at >= ?")
+		args = append(args, filter.After.UTC())
+	}
+	if !filter.Before.IsZero() {
+		conditions = append(conditions, "a.published_at < ?")
+		args = append(args, filter.Before.UTC())
+	}
+	if filter.HasImage {
+		conditions = append(conditions, "IFNULL(a.image_url, '') != ''")
+	}
+	if filter.UnreadOnly {
+		conditions = append(conditions, "NOT EXISTS (SELECT 1 FROM article_state st WHERE st.article_id = a.id AND st.user_id = s.user_id AND st.read = 1)")
+	}
+	if filter.StarredOnly {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM article_state st WHERE st.article_id = a.id AND st.user_id = s.user_id AND st.starred = 1)")
 	}
+	if !filter.AddedAfter.IsZero() {
+		conditions = append(conditions, "a.created_at > ?")
+		args = append(args, filter.AddedAfter.UTC())
+	}
+	return conditions, args
+}
+
+func getFilteredArticles(db *sql.DB, userID int, filter ArticleFilter) ([]Article, error) {
+	var query string
+	args := []interface{}{userID}
+	baseQuery := `
+        SELECT ` + articleListColumns + `
+        FROM articles a
+        JOIN feeds f ON a.feed_id = f.id
+        JOIN subscriptions s ON s.feed_id = a.feed_id AND s.user_id = ?
+    `
+	conditions, filterArgs := filter.conditions()
+	args = append(args, filterArgs...)
 	if len(conditions) > 0 {
 		query = baseQuery + " WHERE " + strings.Join(conditions, " AND ")
 	} else {
@@ -153,55 +984,118 @@ func getFilteredArticles(db *sql.DB, feedID, category string) ([]Article, error)
 	return scanArticles(rows)
 }
 
+// listArticles returns a page of the user's filtered articles, newest
+// first, and the number of articles matching the filter.
+func listArticles(db *sql.DB, userID int, filter ArticleFilter, limit, offset int) ([]Article, int, error) {
+	from, args := searchSource(userID, "", filter)
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*)"+from, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := db.Query("SELECT "+articleListColumns+from+" ORDER BY a.published_at DESC LIMIT ? OFFSET ?",
+		append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	articles, err := scanArticles(rows)
+	return articles, total, err
+}
+
 func scanArticles(rows *sql.Rows) ([]Article, error) {
 	var articles []Article
 	for rows.Next() {
 		var a Article
-		if err := rows.Scan(
-			&a.ID, &a.Title, &a.Summary, &a.URL, &a.FeedID,
-			&a.FeedName, &a.PublishedAt, &a.Category, &a.Sentiment,
-			&a.Bias, &a.ImageURL, &a.CreatedAt); err != nil {
+		if err := rows.Scan(articleFields(&a)...); err != nil {
 			return nil, err
 		}
-		if strings.TrimSpace(a.Summary) == "" || strings.Contains(a.Summary, "readability-page-1") {
-			a.Summary = "No summary available"
-		}
+		fillEmptySummary(&a)
 		articles = append(articles, a)
 	}
 	return articles, nil
 }
 
-func getArticleByID(db *sql.DB, id string) (Article, error) {
+// articleListColumns are the columns selected by the article list queries,
+// over articles a joined with their feed f and the user's subscription s.
+const articleListColumns = `a.id, a.title, a.summary, a.url, a.feed_id, COALESCE(NULLIF(s.title, ''), f.name),
+	a.published_at, a.category, a.sentiment, a.bias,
+	IFNULL(a.image_url, ''), a.created_at, IFNULL(a.sentiment_score, 0), IFNULL(a.story_id, 0),
+	EXISTS (SELECT 1 FROM article_state st WHERE st.article_id = a.id AND st.user_id = s.user_id AND st.read = 1),
+	EXISTS (SELECT 1 FROM article_state st WHERE st.article_id = a.id AND st.user_id = s.user_id AND st.starred = 1)`
+
+// articleFields returns the scan destinations for articleListColumns.
+func articleFields(a *Article) []interface{} {
+	return []interface{}{
+		&a.ID, &a.Title, &a.Summary, &a.URL, &a.FeedID,
+		&a.FeedName, &a.PublishedAt, &a.Category, &a.Sentiment,
+		&a.Bias, &a.ImageURL, &a.CreatedAt, &a.SentimentScore, &a.StoryID,
+		&a.Read, &a.Starred,
+	}
+}
+
+func fillEmptySummary(a *Article) {
+	if strings.TrimSpace(a.Summary) == "" || strings.Contains(a.Summary, "readability-page-1") {
+		a.Summary = "No summary available"
+	}
+}
+
+// articleVisible holds for articles in the user's feeds and for ones they
+// starred before unsubscribing. It needs the user joined as u and their
+// subscription left joined as s.
+const articleVisible = `(s.user_id IS NOT NULL OR EXISTS (SELECT 1 FROM article_state st WHERE st.article_id = a.id AND st.user_id = u.id AND st.starred = 1))`
+
+func getArticleByID(db *sql.DB, userID int, id string) (Article, error) {
 	var a Article
+	var extractedAt sql.NullTime
 	err := db.QueryRow(`
-		SELECT a.id, a.title, a.summary, a.url, a.feed_id, f.name, a.published_at, a.category, a.sentiment, a.bias, IFNULL(a.image_url, ''), a.created_at
+		SELECT a.id, a.title, a.summary, a.url, a.feed_id, COALESCE(NULLIF(s.title, ''), f.name), a.published_at, a.category, a.sentiment, a.bias, IFNULL(a.image_url, ''), a.created_at, IFNULL(a.sentiment_score, 0),
+		       IFNULL(a.content, ''), a.content_extracted_at, IFNULL(a.content_method, ''), IFNULL(a.content_status, ''),
+		       EXISTS (SELECT 1 FROM article_state st WHERE st.article_id = a.id AND st.user_id = u.id AND st.read = 1),
+		       EXISTS (SELECT 1 FROM article_state st WHERE st.article_id = a.id AND st.user_id = u.id AND st.starred = 1)
 		FROM articles a
 		JOIN feeds f ON a.feed_id = f.id
-		WHERE a.id = ?
-	`, id).Scan(&a.ID, &a.Title, &a.Summary, &a.URL, &a.FeedID, &a.FeedName, &a.PublishedAt, &a.Category, &a.Sentiment, &a.Bias, &a.ImageURL, &a.CreatedAt)
+		JOIN users u ON u.id = ?
+		LEFT JOIN subscriptions s ON s.feed_id = a.feed_id AND s.user_id = u.id
+		WHERE a.id = ? AND `+articleVisible+`
+	`, userID, id).Scan(&a.ID, &a.Title, &a.Summary, &a.URL, &a.FeedID, &a.FeedName, &a.PublishedAt, &a.Category, &a.Sentiment, &a.Bias, &a.ImageURL, &a.CreatedAt, &a.SentimentScore,
+		&a.Content, &extractedAt, &a.ContentMethod, &a.ContentStatus, &a.Read, &a.Starred)
+	a.ContentExtractedAt = extractedAt.Time
 	return a, err
 }
 
-func searchArticles(db *sql.DB, query string) ([]Article, error) {
+func saveArticleContent(db *sql.DB, articleID int, content, method, status string, extractedAt time.Time) error {
+	_, err := db.Exec(`
+		UPDATE articles
+		SET content = ?, content_method = ?, content_status = ?, content_extracted_at = ?
+		WHERE id = ?
+	`, content, method, status, extractedAt.UTC(), articleID)
+	if err != nil {
+		return err
+	}
+	return indexArticle(db, articleID)
+}
+
+// getPendingExtractions returns recent articles whose content has not been
+// extracted yet, newest first.
+func getPendingExtractions(db *sql.DB, limit int) ([]Article, error) {
 	rows, err := db.Query(`
-        SELECT a.id, a.title, a.summary, a.url, a.feed_id, f.name, 
-               a.published_at, a.category, a.sentiment, a.bias, 
-               IFNULL(a.image_url, ''), a.created_at
-        FROM articles a
-        JOIN feeds f ON a.feed_id = f.id
-        WHERE a.title LIKE ? OR a.summary LIKE ?
-        ORDER BY a.published_at DESC
-        LIMIT 50
-    `, "%"+query+"%", "%"+query+"%")
+		SELECT id, url
+		FROM articles
+		WHERE content_status IS NULL OR content_status = ''
+		ORDER BY published_at DESC
+		LIMIT ?
+	`, limit)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	return scanArticles(rows)
-}
-
-func cleanupOldArticles(db *sql.DB) error {
-	threshold := time.Now().AddDate(0, 0, -3)
-	_, err := db.Exec("DELETE FROM articles WHERE published_at < ?", threshold)
-	return err
+	var articles []Article
+	for rows.Next() {
+		var a Article
+		if err := rows.Scan(&a.ID, &a.URL); err != nil {
+			return nil, err
+		}
+		articles = append(articles, a)
+	}
+	return articles, rows.Err()
 }
@@ -0,0 +1,143 @@
+//go:build sqlite_fts5
+
+package main
+
+import (
+	"database/sql"
+	"strconv"
+	"testing"
+)
+
+func TestSanitizeStoredArticles(t *testing.T) {
+	db := openTestDB(t)
+	res, err := db.Exec("INSERT INTO feeds (name, url) VALUES ('Feed', 'https://news.example/feed')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	feedID, _ := res.LastInsertId()
+	if _, err := db.Exec(`
+		INSERT INTO articles (title, summary, content, url, feed_id)
+		VALUES ('Raw', '<p onclick="x()">hi<script>alert(1)</script></p>', '<a href="/more">more</a>', 'https://news.example/a', ?)
+	`, feedID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sanitizeStoredArticles(db); err != nil {
+		t.Fatal(err)
+	}
+	var summary, content string
+	if err := db.QueryRow("SELECT summary, content FROM articles").Scan(&summary, &content); err != nil {
+		t.Fatal(err)
+	}
+	if want := "<p>hi</p>"; summary != want {
+		t.Errorf("summary = %q, want %q", summary, want)
+	}
+	if want := `<a href="https://news.example/more"` + linkAttrs + `>more</a>`; content != want {
+		t.Errorf("content = %q, want %q", content, want)
+	}
+}
+
+func TestRunMigrationRunsOnce(t *testing.T) {
+	db := openTestDB(t)
+	runs := 0
+	migrate := func(*sql.DB) error {
+		runs++
+		return nil
+	}
+	for i := 0; i < 2; i++ {
+		if err := runMigration(db, "test-migration", migrate); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if runs != 1 {
+		t.Errorf("migration ran %d times, want 1", runs)
+	}
+}
+
+func TestUnsubscribeKeepsStarredArticles(t *testing.T) {
+	db := openTestDB(t)
+	userID := createTestUser(t, db, "reader")
+	feedID, err := addFeed(db, userID, "Feed", "https://news.example/feed", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var articleIDs []int
+	for _, title := range []string{"Starred", "Plain"} {
+		res, err := db.Exec("INSERT INTO articles (title, summary, url, feed_id, published_at) VALUES (?, '', ?, ?, CURRENT_TIMESTAMP)",
+			title, "https://news.example/"+title, feedID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := res.LastInsertId()
+		articleIDs = append(articleIDs, int(id))
+	}
+	if err := setArticleStarred(db, userID, articleIDs[0], true); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := unsubscribeFeed(db, userID, strconv.Itoa(feedID)); err != nil {
+		t.Fatal(err)
+	}
+
+	var titles []string
+	rows, err := db.Query("SELECT title FROM articles ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var title string
+		if err := rows.Scan(&title); err != nil {
+			t.Fatal(err)
+		}
+		titles = append(titles, title)
+	}
+	if len(titles) != 1 || titles[0] != "Starred" {
+		t.Errorf("articles left = %v, want [Starred]", titles)
+	}
+
+	starred, total, err := searchArticles(db, userID, "", ArticleFilter{StarredOnly: true}, searchByDate, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 || len(starred) != 1 || starred[0].FeedName != "Feed" || !starred[0].Starred {
+		t.Fatalf("starred list = %+v (total %d), want the starred article from Feed", starred, total)
+	}
+	if _, err := getArticleByID(db, userID, strconv.Itoa(articleIDs[0])); err != nil {
+		t.Errorf("starred article after unsubscribing: %v", err)
+	}
+	if err := setArticleRead(db, userID, articleIDs[0], true); err != nil {
+		t.Errorf("marking starred article read: %v", err)
+	}
+
+	// Another user sees neither the article nor the feed's stars.
+	otherID := createTestUser(t, db, "other")
+	if _, err := getArticleByID(db, otherID, strconv.Itoa(articleIDs[0])); err != sql.ErrNoRows {
+		t.Errorf("other user's getArticleByID error = %v, want sql.ErrNoRows", err)
+	}
+	if _, total, _ := searchArticles(db, otherID, "", ArticleFilter{StarredOnly: true}, searchByDate, 10, 0); total != 0 {
+		t.Errorf("other user's starred total = %d, want 0", total)
+	}
+}
+
+func TestUnsubscribeDropsUnstarredFeed(t *testing.T) {
+	db := openTestDB(t)
+	userID := createTestUser(t, db, "reader")
+	feedID, err := addFeed(db, userID, "Feed", "https://news.example/feed", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO articles (title, summary, url, feed_id) VALUES ('Plain', '', 'https://news.example/p', ?)", feedID); err != nil {
+		t.Fatal(err)
+	}
+	if err := unsubscribeFeed(db, userID, strconv.Itoa(feedID)); err != nil {
+		t.Fatal(err)
+	}
+	var feeds, articles int
+	if err := db.QueryRow("SELECT (SELECT COUNT(*) FROM feeds), (SELECT COUNT(*) FROM articles)").Scan(&feeds, &articles); err != nil {
+		t.Fatal(err)
+	}
+	if feeds != 0 || articles != 0 {
+		t.Errorf("%d feeds and %d articles left, want none", feeds, articles)
+	}
+}
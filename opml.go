@@ -0,0 +1,222 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// OPML 2.0 document structure, see http://opml.org/spec2.opml.
+type opmlDocument struct {
+	XMLName xml.Name `xml:"opml"`
+	Version string   `xml:"version,attr"`
+	Head    opmlHead `xml:"head"`
+	Body    opmlBody `xml:"body"`
+}
+
+type opmlHead struct {
+	Title       string `xml:"title"`
+	DateCreated string `xml:"dateCreated,omitempty"`
+}
+
+type opmlBody struct {
+	Outlines []opmlOutline `xml:"outline"`
+}
+
+type opmlOutline struct {
+	Text     string        `xml:"text,attr"`
+	Title    string        `xml:"title,attr,omitempty"`
+	Type     string        `xml:"type,attr,omitempty"`
+	XMLURL   string        `xml:"xmlUrl,attr,omitempty"`
+	HTMLURL  string        `xml:"htmlUrl,attr,omitempty"`
+	Outlines []opmlOutline `xml:"outline"`
+}
+
+// OPMLImportResult reports what happened to one feed outline on import.
+type OPMLImportResult struct {
+	Title   string `json:"title"`
+	URL     string `json:"url"`
+	Folder  string `json:"folder,omitempty"`
+	Status  string `json:"status"` // "added", "duplicate" or "error"
+	Message string `json:"message,omitempty"`
+}
+
+// opmlFolderSeparator joins nested outline folder names.
+const opmlFolderSeparator = " / "
+
+// importOPML subscribes the user to every feed outline in the document.
+// Outlines without an xmlUrl are treated as folders for the feeds nested in
+// them. New feeds are left for the scheduler to fetch.
+func importOPML(db *sql.DB, userID int, r io.Reader) ([]OPMLImportResult, error) {
+	var doc opmlDocument
+	decoder := xml.NewDecoder(r)
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		enc, err := htmlindex.Get(charset)
+		if err != nil {
+			return nil, err
+		}
+		return enc.NewDecoder().Reader(input), nil
+	}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid OPML: %v", err)
+	}
+
+	var results []OPMLImportResult
+	var walk func(outlines []opmlOutline, folders []string)
+	walk = func(outlines []opmlOutline, folders []string) {
+		for _, o := range outlines {
+			title := strings.TrimSpace(o.Title)
+			if title == "" {
+				title = strings.TrimSpace(o.Text)
+			}
+			if o.XMLURL == "" {
+				if len(o.Outlines) > 0 {
+					walk(o.Outlines, append(folders, title))
+				}
+				continue
+			}
+			results = append(results, importOPMLOutline(db, userID, title, strings.TrimSpace(o.XMLURL), strings.Join(folders, opmlFolderSeparator)))
+			if len(o.Outlines) > 0 {
+				walk(o.Outlines, folders)
+			}
+		}
+	}
+	walk(doc.Body.Outlines, nil)
+	return results, nil
+}
+
+func importOPMLOutline(db *sql.DB, userID int, title, feedURL, folder string) OPMLImportResult {
+	result := OPMLImportResult{Title: title, URL: feedURL, Folder: folder}
+	u, err := url.Parse(feedURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		result.Status = "error"
+		result.Message = "invalid feed URL"
+		return result
+	}
+	if title == "" {
+		title = feedHost(feedURL)
+		result.Title = title
+	}
+	if _, err := addFeed(db, userID, title, feedURL, folder); err != nil {
+		if errors.Is(err, errAlreadySubscribed) {
+			result.Status = "duplicate"
+			result.Message = "already subscribed"
+			return result
+		}
+		result.Status = "error"
+		result.Message = err.Error()
+		return result
+	}
+	result.Status = "added"
+	return result
+}
+
+// exportOPML writes the user's subscriptions as an OPML 2.0 document,
+// nesting feeds under their folders.
+func exportOPML(w io.Writer, username string, feeds []Feed) error {
+	doc := opmlDocument{
+		Version: "2.0",
+		Head: opmlHead{
+			Title:       "Suprnews subscriptions for " + username,
+			DateCreated: time.Now().UTC().Format(time.RFC1123Z),
+		},
+	}
+	folderIndex := make(map[string]int)
+	for _, f := range feeds {
+		outline := opmlOutline{Text: f.Name, Title: f.Name, Type: "rss", XMLURL: f.URL}
+		if f.Folder == "" {
+			doc.Body.Outlines = append(doc.Body.Outlines, outline)
+			continue
+		}
+		// Nested folders are stored joined; rebuild the outline tree.
+		parent := &doc.Body.Outlines
+		path := ""
+		for _, name := range strings.Split(f.Folder, opmlFolderSeparator) {
+			path += opmlFolderSeparator + name
+			idx, ok := folderIndex[path]
+			if !ok {
+				*parent = append(*parent, opmlOutline{Text: name, Title: name})
+				idx = len(*parent) - 1
+				folderIndex[path] = idx
+			}
+			parent = &(*parent)[idx].Outlines
+		}
+		*parent = append(*parent, outline)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	return encoder.Encode(doc)
+}
+
+// importOPMLHandler accepts an OPML upload from the /feeds page form, or a
+// raw OPML request body from scripts, which get a JSON report back.
+func importOPMLHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := currentUser(r)
+
+	fromForm := strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data")
+	r.Body = http.MaxBytesReader(w, r.Body, 10<<20)
+	var body io.Reader = r.Body
+	if fromForm {
+		file, _, err := r.FormFile("opml")
+		if err != nil {
+			http.Error(w, "OPML file is required", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+
+	results, err := importOPML(db, user.ID, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("OPML import for %s: %d outlines processed", user.Username, len(results))
+
+	if !fromForm {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(results); err != nil {
+			log.Printf("Error writing OPML import report: %v", err)
+		}
+		return
+	}
+	data, err := loadFeedsPage(r)
+	if err != nil {
+		http.Error(w, "Failed to load feeds: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data.ImportResults = results
+	renderTemplate(w, r, "feeds.html", data)
+}
+
+func exportOPMLHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	feeds, err := getFeeds(db, user.ID)
+	if err != nil {
+		http.Error(w, "Failed to load feeds: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/x-opml; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="suprnews-subscriptions.opml"`)
+	if err := exportOPML(w, user.Username, feeds); err != nil {
+		log.Printf("Error writing OPML export: %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+//go:build sqlite_fts5
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestOPMLRoundTrip(t *testing.T) {
+	feeds := []Feed{
+		{Name: "Top level", URL: "https://a.example/feed"},
+		{Name: "Tech", URL: "https://b.example/rss", Folder: "News"},
+		{Name: "Go blog", URL: "https://c.example/atom.xml", Folder: "News / Programming / Go"},
+		{Name: "Rust blog", URL: "https://d.example/feed.xml", Folder: "News / Programming"},
+		{Name: "Cooking", URL: "https://e.example/feed", Folder: "Hobbies"},
+	}
+	var buf bytes.Buffer
+	if err := exportOPML(&buf, "alice", feeds); err != nil {
+		t.Fatal(err)
+	}
+
+	db := openTestDB(t)
+	userID := createTestUser(t, db, "bob")
+	results, err := importOPML(db, userID, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(feeds) {
+		t.Fatalf("imported %d outlines, want %d: %+v", len(results), len(feeds), results)
+	}
+	for _, r := range results {
+		if r.Status != "added" {
+			t.Errorf("%s: status %q (%s), want added", r.URL, r.Status, r.Message)
+		}
+	}
+
+	got, err := getFeeds(db, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byURL := make(map[string]Feed)
+	for _, f := range got {
+		byURL[f.URL] = f
+	}
+	for _, want := range feeds {
+		f, ok := byURL[want.URL]
+		if !ok {
+			t.Errorf("%s was not imported", want.URL)
+			continue
+		}
+		if f.Name != want.Name || f.Folder != want.Folder {
+			t.Errorf("%s: got %q in %q, want %q in %q", want.URL, f.Name, f.Folder, want.Name, want.Folder)
+		}
+	}
+}
+
+func TestImportOPMLReportsDuplicatesAndBadURLs(t *testing.T) {
+	doc := `<?xml version="1.0"?>
+<opml version="2.0"><body>
+  <outline text="Folder">
+    <outline text="One" xmlUrl="https://a.example/feed"/>
+    <outline text="Again" xmlUrl="https://a.example/feed"/>
+    <outline text="Bad" xmlUrl="ftp://b.example/feed"/>
+  </outline>
+</body></opml>`
+	db := openTestDB(t)
+	userID := createTestUser(t, db, "carol")
+	results, err := importOPML(db, userID, strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"added", "duplicate", "error"}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(results), len(want))
+	}
+	for i, r := range results {
+		if r.Status != want[i] {
+			t.Errorf("%s: status %q, want %q", r.Title, r.Status, want[i])
+		}
+		if r.Folder != "Folder" {
+			t.Errorf("%s: folder %q, want Folder", r.Title, r.Folder)
+		}
+	}
+}
+
+func TestImportOPMLRejectsInvalidXML(t *testing.T) {
+	if _, err := importOPML(nil, 1, strings.NewReader("<opml><body>")); err == nil {
+		t.Error("want an error for truncated OPML")
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UnreadCount is the number of unread articles in a feed or category.
+type UnreadCount struct {
+	Key   string // feed ID or category slug, for the link
+	Name  string
+	Count int
+}
+
+// UnreadCounts breaks down a user's unread articles for the sidebar.
+type UnreadCounts struct {
+	Total      int
+	Feeds      []UnreadCount
+	Categories []UnreadCount
+}
+
+// setArticleRead marks one of the user's articles read or unread. It
+// returns sql.ErrNoRows when the article is not visible to the user.
+func setArticleRead(db *sql.DB, userID, articleID int, read bool) error {
+	defer forgetSidebar(userID)
+	var readAt interface{}
+	if read {
+		readAt = time.Now().UTC()
+	}
+	res, err := db.Exec(`
+		INSERT INTO article_state (user_id, article_id, read, read_at)
+		SELECT u.id, a.id, ?, ?
+		FROM articles a
+		JOIN users u ON u.id = ?
+		LEFT JOIN subscriptions s ON s.feed_id = a.feed_id AND s.user_id = u.id
+		WHERE a.id = ? AND `+articleVisible+`
+		ON CONFLICT (user_id, article_id) DO UPDATE SET read = excluded.read, read_at = excluded.read_at
+	`, read, readAt, userID, articleID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// setArticleStarred stars or unstars one of the user's articles. It returns
+// sql.ErrNoRows when the article is not visible to the user.
+func setArticleStarred(db *sql.DB, userID, articleID int, starred bool) error {
+	var starredAt interface{}
+	if starred {
+		starredAt = time.Now().UTC()
+	}
+	res, err := db.Exec(`
+		INSERT INTO article_state (user_id, article_id, starred, starred_at)
+		SELECT u.id, a.id, ?, ?
+		FROM articles a
+		JOIN users u ON u.id = ?
+		LEFT JOIN subscriptions s ON s.feed_id = a.feed_id AND s.user_id = u.id
+		WHERE a.id = ? AND `+articleVisible+`
+		ON CONFLICT (user_id, article_id) DO UPDATE SET starred = excluded.starred, starred_at = excluded.starred_at
+	`, starred, starredAt, userID, articleID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// markArticlesRead marks every one of the user's articles matching the
+// filter as read and returns how many were unread.
+func markArticlesRead(db *sql.DB, userID int, filter ArticleFilter) (int64, error) {
+	defer forgetSidebar(userID)
+	filter.UnreadOnly = true
+	conditions, filterArgs := filter.conditions()
+	// An upsert from a SELECT needs a WHERE clause to parse unambiguously.
+	conditions = append([]string{"1"}, conditions...)
+	args := append([]interface{}{time.Now().UTC(), userID}, filterArgs...)
+	res, err := db.Exec(`
+		INSERT INTO article_state (user_id, article_id, read, read_at)
+		SELECT s.user_id, a.id, 1, ?
+		FROM articles a
+		JOIN feeds f ON a.feed_id = f.id
+		JOIN subscriptions s ON s.feed_id = a.feed_id AND s.user_id = ?
+		WHERE `+strings.Join(conditions, " AND ")+`
+		ON CONFLICT (user_id, article_id) DO UPDATE SET read = 1, read_at = excluded.read_at
+	`, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// getUnreadCounts counts the user's unread articles per feed and category.
+func getUnreadCounts(db *sql.DB, userID int) (UnreadCounts, error) {
+	var counts UnreadCounts
+	unread := `
+		FROM articles a
+		JOIN feeds f ON a.feed_id = f.id
+		JOIN subscriptions s ON s.feed_id = a.feed_id AND s.user_id = ?
+		WHERE NOT EXISTS (SELECT 1 FROM article_state st WHERE st.article_id = a.id AND st.user_id = s.user_id AND st.read = 1)
+	`
+	rows, err := db.Query(`
+		SELECT a.feed_id, COALESCE(NULLIF(s.title, ''), f.name), COUNT(*)
+	`+unread+`
+		GROUP BY a.feed_id
+		ORDER BY COALESCE(NULLIF(s.title, ''), f.name) COLLATE NOCASE
+	`, userID)
+	if err != nil {
+		return counts, err
+	}
+	for rows.Next() {
+		var c UnreadCount
+		if err := rows.Scan(&c.Key, &c.Name, &c.Count); err != nil {
+			rows.Close()
+			return counts, err
+		}
+		counts.Feeds = append(counts.Feeds, c)
+		counts.Total += c.Count
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return counts, err
+	}
+
+	rows, err = db.Query("SELECT LOWER(a.category), COUNT(*)"+unread+" GROUP BY LOWER(a.category)", userID)
+	if err != nil {
+		return counts, err
+	}
+	byCategory := make(map[string]int)
+	for rows.Next() {
+		var slug string
+		var n int
+		if err := rows.Scan(&slug, &n); err != nil {
+			rows.Close()
+			return counts, err
+		}
+		byCategory[slug] = n
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return counts, err
+	}
+	categories := currentCategories()
+	categories = append(categories[:len(categories):len(categories)], Category{Name: "Other", Slug: otherCategory})
+	for _, c := range categories {
+		if n := byCategory[c.Slug]; n > 0 {
+			counts.Categories = append(counts.Categories, UnreadCount{Key: c.Slug, Name: c.Name, Count: n})
+		}
+	}
+	return counts, nil
+}
+
+// pruneArticleState drops the read state of deleted articles.
+func pruneArticleState(db *sql.DB) error {
+	_, err := db.Exec("DELETE FROM article_state WHERE article_id NOT IN (SELECT id FROM articles)")
+	return err
+}
+
+// markReadHandler marks all articles of a feed or category, or all of
+// them, as read, optionally only those older than a given age, and returns
+// to the home page with the same filters.
+func markReadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	filter := ArticleFilter{
+		FeedID:   r.FormValue("feed"),
+		Category: r.FormValue("category"),
+	}
+	if olderThan := r.FormValue("older_than"); olderThan != "" {
+		age, err := time.ParseDuration(olderThan)
+		if err != nil || age <= 0 {
+			http.Error(w, "Invalid age", http.StatusBadRequest)
+			return
+		}
+		filter.Before = time.Now().Add(-age)
+	}
+	user := currentUser(r)
+	n, err := markArticlesRead(db, user.ID, filter)
+	if err != nil {
+		http.Error(w, "Failed to mark articles as read: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Marked %d articles as read for %s", n, user.Username)
+
+	back := url.Values{}
+	for _, key := range []string{"feed", "category", "unread"} {
+		if v := r.FormValue(key); v != "" {
+			back.Set(key, v)
+		}
+	}
+	http.Redirect(w, r, "/?"+back.Encode(), http.StatusSeeOther)
+}
+
+// markUnreadHandler marks an opened article as unread again.
+func markUnreadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.Atoi(r.URL.Path[len("/article/unread/"):])
+	if err != nil {
+		http.Error(w, "Invalid article ID", http.StatusBadRequest)
+		return
+	}
+	if err := setArticleRead(db, currentUser(r).ID, id, false); err != nil {
+		if err == sql.ErrNoRows {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "Failed to mark article as unread: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// starHandler stars or unstars an article and returns to the page the
+// request came from.
+func starHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.Atoi(r.URL.Path[len("/article/star/"):])
+	if err != nil {
+		http.Error(w, "Invalid article ID", http.StatusBadRequest)
+		return
+	}
+	if err := setArticleStarred(db, currentUser(r).ID, id, r.FormValue("starred") == "1"); err != nil {
+		if err == sql.ErrNoRows {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "Failed to star article: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	back := "/"
+	if ref, err := url.Parse(r.Referer()); err == nil && ref.Host == r.Host && ref.Path != "" {
+		back = ref.RequestURI()
+	}
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// starredHandler lists the user's starred articles, newest first.
+func starredHandler(w http.ResponseWriter, r *http.Request) {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	user := currentUser(r)
+	articles, total, err := searchArticles(db, user.ID, "", ArticleFilter{StarredOnly: true}, searchByDate, searchPageSize, (page-1)*searchPageSize)
+	if err != nil {
+		log.Printf("[ERROR] Error getting starred articles: %v", err)
+		http.Error(w, "Failed to load articles", http.StatusInternalServerError)
+		return
+	}
+	if err := attachArticleEntities(db, articles); err != nil {
+		log.Printf("[ERROR] Error getting article entities: %v", err)
+	}
+	renderTemplate(w, r, "index.html", PageData{
+		Articles:   articles,
+		Active:     "starred",
+		Count:      total,
+		Page:       page,
+		TotalPages: (total + searchPageSize - 1) / searchPageSize,
+		PageURL:    "/starred?page=",
+	})
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// Items published longer ago than this are skipped when a feed is fetched.
+	retentionIngestMaxAge = getEnvDuration("RETENTION_INGEST_MAX_AGE", 72*time.Hour)
+	// Articles published longer ago than this are deleted by the cleanup.
+	retentionMaxAge = getEnvDuration("RETENTION_MAX_AGE", 72*time.Hour)
+	// The cleanup keeps at most this many of a feed's newest articles.
+	retentionMaxItems = getEnvInt("RETENTION_MAX_ITEMS", 0)
+)
+
+// RetentionPolicy decides which of a feed's items are stored when it is
+// fetched and how long they are kept. A zero age or item count is no limit.
+type RetentionPolicy struct {
+	IngestMaxAge time.Duration
+	MaxAge       time.Duration
+	MaxItems     int
+}
+
+func defaultRetention() RetentionPolicy {
+	return RetentionPolicy{
+		IngestMaxAge: retentionIngestMaxAge,
+		MaxAge:       retentionMaxAge,
+		MaxItems:     retentionMaxItems,
+	}
+}
+
+// tooOldToIngest reports whether an item published at pubDate is skipped.
+func (p RetentionPolicy) tooOldToIngest(pubDate, now time.Time) bool {
+	return p.IngestMaxAge > 0 && pubDate.Before(now.Add(-p.IngestMaxAge))
+}
+
+// FeedRetention holds an admin's overrides of the default retention policy
+// for one feed; nil fields follow the default.
+type FeedRetention struct {
+	IngestMaxAge *time.Duration
+	MaxAge       *time.Duration
+	MaxItems     *int
+}
+
+func (r FeedRetention) policy() RetentionPolicy {
+	p := defaultRetention()
+	if r.IngestMaxAge != nil {
+		p.IngestMaxAge = *r.IngestMaxAge
+	}
+	if r.MaxAge != nil {
+		p.MaxAge = *r.MaxAge
+	}
+	if r.MaxItems != nil {
+		p.MaxItems = *r.MaxItems
+	}
+	return p
+}
+
+// Overridden reports whether the feed has any retention setting of its own.
+func (r FeedRetention) Overridden() bool {
+	return r.IngestMaxAge != nil || r.MaxAge != nil || r.MaxItems != nil
+}
+
+// scanFeedRetention converts the feed's nullable retention columns, ages
+// stored in seconds.
+func scanFeedRetention(ingestMaxAge, maxAge, maxItems sql.NullInt64) FeedRetention {
+	var r FeedRetention
+	if ingestMaxAge.Valid {
+		d := time.Duration(ingestMaxAge.Int64) * time.Second
+		r.IngestMaxAge = &d
+	}
+	if maxAge.Valid {
+		d := time.Duration(maxAge.Int64) * time.Second
+		r.MaxAge = &d
+	}
+	if maxItems.Valid {
+		n := int(maxItems.Int64)
+		r.MaxItems = &n
+	}
+	return r
+}
+
+func setFeedRetention(db *sql.DB, feedID int, r FeedRetention) error {
+	seconds := func(d *time.Duration) interface{} {
+		if d == nil {
+			return nil
+		}
+		return int64(*d / time.Second)
+	}
+	var maxItems interface{}
+	if r.MaxItems != nil {
+		maxItems = *r.MaxItems
+	}
+	res, err := db.Exec(`
+		UPDATE feeds SET ingest_max_age = ?, retention_max_age = ?, retention_max_items = ?
+		WHERE id = ?
+	`, seconds(r.IngestMaxAge), seconds(r.MaxAge), maxItems, feedID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// parseRetentionAge reads an age such as "7d" or "36h". An empty string
+// means no override and "0" means no limit.
+func parseRetentionAge(s string) (*time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	var d time.Duration
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return nil, fmt.Errorf("invalid age %q", s)
+		}
+		d = time.Duration(n) * 24 * time.Hour
+	} else if s == "0" {
+		d = 0
+	} else {
+		var err error
+		if d, err = time.ParseDuration(s); err != nil {
+			return nil, fmt.Errorf("invalid age %q", s)
+		}
+	}
+	if d < 0 {
+		return nil, fmt.Errorf("invalid age %q", s)
+	}
+	return &d, nil
+}
+
+func parseRetentionItems(s string) (*int, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid item count %q", s)
+	}
+	return &n, nil
+}
+
+// formatRetentionAge writes an age the way parseRetentionAge reads it.
+func formatRetentionAge(d time.Duration) string {
+	switch {
+	case d == 0:
+		return "0"
+	case d%(24*time.Hour) == 0:
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	}
+	return d.String()
+}
+
+// ExpiredArticle is an article the next cleanup would delete.
+type ExpiredArticle struct {
+	ID          int
+	Title       string
+	PublishedAt time.Time
+	Reason      string // "age" or "count"
+}
+
+// RetentionReport is what the retention policy of one feed would delete.
+type RetentionReport struct {
+	Feed    Feed
+	Policy  RetentionPolicy
+	Stored  int
+	Starred int // past the limits but kept because they are starred
+	Expired []ExpiredArticle
+}
+
+// planRetention works out, feed by feed, which stored articles are past
+// their feed's age or item limit as of now. Starred articles are counted
+// but never expire.
+func planRetention(db *sql.DB, now time.Time) ([]RetentionReport, error) {
+	rows, err := db.Query(`
+		SELECT id, name, url, ingest_max_age, retention_max_age, retention_max_items
+		FROM feeds
+		ORDER BY name COLLATE NOCASE
+	`)
+	if err != nil {
+		return nil, err
+	}
+	var reports []RetentionReport
+	for rows.Next() {
+		var f Feed
+		var ingestMaxAge, maxAge, maxItems sql.NullInt64
+		if err := rows.Scan(&f.ID, &f.Name, &f.URL, &ingestMaxAge, &maxAge, &maxItems); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		f.Retention = scanFeedRetention(ingestMaxAge, maxAge, maxItems)
+		reports = append(reports, RetentionReport{Feed: f, Policy: f.Retention.policy()})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range reports {
+		if err := reports[i].plan(db, now); err != nil {
+			return nil, err
+		}
+	}
+	return reports, nil
+}
+
+func (r *RetentionReport) plan(db *sql.DB, now time.Time) error {
+	rows, err := db.Query(`
+		SELECT a.id, a.title, a.published_at,
+		       EXISTS (SELECT 1 FROM article_state st WHERE st.article_id = a.id AND st.starred = 1)
+		FROM articles a
+		WHERE a.feed_id = ?
+		ORDER BY a.published_at DESC, a.id DESC
+	`, r.Feed.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	threshold := now.Add(-r.Policy.MaxAge)
+	for rows.Next() {
+		var a ExpiredArticle
+		var publishedAt sql.NullTime
+		var starred bool
+		if err := rows.Scan(&a.ID, &a.Title, &publishedAt, &starred); err != nil {
+			return err
+		}
+		a.PublishedAt = publishedAt.Time
+		r.Stored++
+		switch {
+		case r.Policy.MaxAge > 0 && publishedAt.Valid && publishedAt.Time.Before(threshold):
+			a.Reason = "age"
+		case r.Policy.MaxItems > 0 && r.Stored > r.Policy.MaxItems:
+			a.Reason = "count"
+		default:
+			continue
+		}
+		if starred {
+			r.Starred++
+			continue
+		}
+		r.Expired = append(r.Expired, a)
+	}
+	return rows.Err()
+}
+
+// cleanupOldArticles deletes the articles past their feed's retention
+// limits unless someone starred them.
+func cleanupOldArticles(db *sql.DB) error {
+	reports, err := planRetention(db, time.Now())
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// An article starred since the plan was made is still kept.
+	stmt, err := tx.Prepare(`
+		DELETE FROM articles
+		WHERE id = ?
+		  AND id NOT IN (SELECT article_id FROM article_state WHERE starred = 1)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	var deleted int64
+	for _, r := range reports {
+		for _, a := range r.Expired {
+			res, err := stmt.Exec(a.ID)
+			if err != nil {
+				return err
+			}
+			n, _ := res.RowsAffected()
+			deleted += n
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if deleted > 0 {
+		log.Printf("Deleted %d articles past their retention limits", deleted)
+		forgetAllSidebars()
+	}
+	return nil
+}
+
+func retentionHandler(w http.ResponseWriter, r *http.Request) {
+	reports, err := planRetention(db, time.Now())
+	if err != nil {
+		http.Error(w, "Failed to plan cleanup: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	renderTemplate(w, r, "retention.html", PageData{
+		Active:           "retention",
+		Retention:        reports,
+		DefaultRetention: defaultRetention(),
+	})
+}
+
+// feedRetentionHandler saves a feed's retention overrides; empty fields
+// return the feed to the default policy.
+func feedRetentionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	feedID, err := strconv.Atoi(r.URL.Path[len("/admin/retention/feed/"):])
+	if err != nil {
+		http.Error(w, "Invalid feed ID", http.StatusBadRequest)
+		return
+	}
+	ingestMaxAge, err := parseRetentionAge(r.FormValue("ingest_max_age"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	maxAge, err := parseRetentionAge(r.FormValue("max_age"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	maxItems, err := parseRetentionItems(r.FormValue("max_items"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	settings := FeedRetention{IngestMaxAge: ingestMaxAge, MaxAge: maxAge, MaxItems: maxItems}
+	if err := setFeedRetention(db, feedID, settings); err != nil {
+		if err == sql.ErrNoRows {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "Failed to save retention settings: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/admin/retention", http.StatusSeeOther)
+}
@@ -0,0 +1,177 @@
+//go:build sqlite_fts5
+
+package main
+
+import (
+	"database/sql"
+	"slices"
+	"testing"
+	"time"
+)
+
+type retentionArticle struct {
+	title   string
+	age     time.Duration // before now; zero leaves published_at NULL
+	starred bool
+}
+
+func durationPtr(d time.Duration) *time.Duration { return &d }
+
+func intPtr(n int) *int { return &n }
+
+var planRetentionTests = []struct {
+	name      string
+	override  FeedRetention
+	articles  []retentionArticle
+	want      []string // expired titles with their reason, newest first
+	wantKept  int      // starred articles past the limits
+	wantTotal int
+}{
+	{
+		name:      "default age limit",
+		articles:  []retentionArticle{{title: "fresh", age: time.Hour}, {title: "stale", age: 100 * time.Hour}},
+		want:      []string{"stale:age"},
+		wantTotal: 2,
+	},
+	{
+		name:     "item limit",
+		override: FeedRetention{MaxAge: durationPtr(0), MaxItems: intPtr(2)},
+		articles: []retentionArticle{
+			{title: "a", age: time.Hour}, {title: "b", age: 2 * time.Hour},
+			{title: "c", age: 3 * time.Hour}, {title: "d", age: 4 * time.Hour},
+		},
+		want:      []string{"c:count", "d:count"},
+		wantTotal: 4,
+	},
+	{
+		name:     "age reported before count",
+		override: FeedRetention{MaxAge: durationPtr(24 * time.Hour), MaxItems: intPtr(1)},
+		articles: []retentionArticle{
+			{title: "new", age: time.Hour}, {title: "recent", age: 2 * time.Hour}, {title: "old", age: 48 * time.Hour},
+		},
+		want:      []string{"recent:count", "old:age"},
+		wantTotal: 3,
+	},
+	{
+		name:     "starred never expire",
+		override: FeedRetention{MaxItems: intPtr(1)},
+		articles: []retentionArticle{
+			{title: "new", age: time.Hour}, {title: "kept", age: 2 * time.Hour, starred: true},
+			{title: "old", age: 200 * time.Hour, starred: true}, {title: "gone", age: 300 * time.Hour},
+		},
+		want:      []string{"gone:age"},
+		wantKept:  2,
+		wantTotal: 4,
+	},
+	{
+		name:      "no limits",
+		override:  FeedRetention{MaxAge: durationPtr(0), MaxItems: intPtr(0)},
+		articles:  []retentionArticle{{title: "ancient", age: 1000 * time.Hour}},
+		wantTotal: 1,
+	},
+	{
+		name:      "unknown publication date",
+		articles:  []retentionArticle{{title: "undated"}},
+		wantTotal: 1,
+	},
+}
+
+func TestPlanRetention(t *testing.T) {
+	defer func(age time.Duration, items int) {
+		retentionMaxAge, retentionMaxItems = age, items
+	}(retentionMaxAge, retentionMaxItems)
+	retentionMaxAge, retentionMaxItems = 72*time.Hour, 0
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	for _, tc := range planRetentionTests {
+		t.Run(tc.name, func(t *testing.T) {
+			db := openTestDB(t)
+			feedID := insertRetentionFeed(t, db, tc.override, tc.articles, now)
+
+			reports, err := planRetention(db, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(reports) != 1 || reports[0].Feed.ID != feedID {
+				t.Fatalf("got %d reports, want one for feed %d", len(reports), feedID)
+			}
+			r := reports[0]
+			var got []string
+			for _, a := range r.Expired {
+				got = append(got, a.Title+":"+a.Reason)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("expired = %v, want %v", got, tc.want)
+			}
+			if r.Starred != tc.wantKept {
+				t.Errorf("starred kept = %d, want %d", r.Starred, tc.wantKept)
+			}
+			if r.Stored != tc.wantTotal {
+				t.Errorf("stored = %d, want %d", r.Stored, tc.wantTotal)
+			}
+		})
+	}
+}
+
+func TestCleanupOldArticlesKeepsStarred(t *testing.T) {
+	db := openTestDB(t)
+	insertRetentionFeed(t, db, FeedRetention{MaxAge: durationPtr(time.Hour)}, []retentionArticle{
+		{title: "fresh", age: time.Minute},
+		{title: "starred", age: 48 * time.Hour, starred: true},
+		{title: "stale", age: 48 * time.Hour},
+	}, time.Now())
+
+	if err := cleanupOldArticles(db); err != nil {
+		t.Fatal(err)
+	}
+	var titles []string
+	rows, err := db.Query("SELECT title FROM articles ORDER BY title")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var title string
+		if err := rows.Scan(&title); err != nil {
+			t.Fatal(err)
+		}
+		titles = append(titles, title)
+	}
+	if want := []string{"fresh", "starred"}; !slices.Equal(titles, want) {
+		t.Errorf("articles left = %v, want %v", titles, want)
+	}
+}
+
+// insertRetentionFeed stores a feed with the given overrides and articles
+// published the given time before now.
+func insertRetentionFeed(t *testing.T, db *sql.DB, override FeedRetention, articles []retentionArticle, now time.Time) int {
+	t.Helper()
+	userID := createTestUser(t, db, "reader")
+	res, err := db.Exec("INSERT INTO feeds (name, url) VALUES ('Feed', 'https://news.example/feed')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+	feedID := int(id)
+	if err := setFeedRetention(db, feedID, override); err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range articles {
+		var published interface{}
+		if a.age > 0 {
+			published = now.Add(-a.age).UTC()
+		}
+		res, err := db.Exec("INSERT INTO articles (title, summary, url, feed_id, published_at) VALUES (?, '', ?, ?, ?)",
+			a.title, "https://news.example/"+a.title, feedID, published)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.starred {
+			articleID, _ := res.LastInsertId()
+			if _, err := db.Exec("INSERT INTO article_state (user_id, article_id, starred) VALUES (?, ?, 1)", userID, articleID); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return feedID
+}
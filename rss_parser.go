@@ -2,12 +2,17 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"regexp"
+	"slices"
 	"strings"
 	"time"
 
@@ -21,226 +26,400 @@ import (
 )
 
 func runBackgroundTasks() {
-	ticker := time.NewTicker(5 * time.Minute)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		log.Println("Refreshing RSS feeds...")
-		if err := parseRSSFeeds(db); err != nil {
-			log.Println("Error refreshing feeds:", err)
-		}
-		if err := cleanupOldArticles(db); err != nil {
-			log.Println("Error cleaning up articles:", err)
+	scheduler := time.NewTicker(schedulerTick)
+	defer scheduler.Stop()
+	cleanup := time.NewTicker(5 * time.Minute)
+	defer cleanup.Stop()
+
+	for {
+		select {
+		case <-scheduler.C:
+			if err := refreshDueFeeds(db); err != nil {
+				log.Println("Error refreshing feeds:", err)
+			}
+		case <-cleanup.C:
+			if err := cleanupOldArticles(db); err != nil {
+				log.Println("Error cleaning up articles:", err)
+			}
+			if err := cleanupExpiredSessions(db); err != nil {
+				log.Println("Error cleaning up sessions:", err)
+			}
+			if err := pruneEntities(db); err != nil {
+				log.Println("Error cleaning up entities:", err)
+			}
+			if err := pruneArticleState(db); err != nil {
+				log.Println("Error cleaning up read state:", err)
+			}
+			if err := queuePendingExtractions(db); err != nil {
+				log.Println("Error queueing content extraction:", err)
+			}
 		}
 	}
 }
 
+// parseRSSFeeds refreshes every subscribed feed regardless of its schedule.
 func parseRSSFeeds(db *sql.DB) error {
-	log.Println("Starting parseRSSFeeds...")
-	feeds, err := getFeeds(db)
+	return runRefreshCycle(db, "parseRSSFeeds", getAllFeeds)
+}
+
+// refreshDueFeeds refreshes the feeds whose next check time has passed.
+func refreshDueFeeds(db *sql.DB) error {
+	return runRefreshCycle(db, "refreshDueFeeds", func(db *sql.DB) ([]Feed, error) {
+		return getDueFeeds(db, time.Now())
+	})
+}
+
+func runRefreshCycle(db *sql.DB, name string, load func(*sql.DB) ([]Feed, error)) error {
+	if !refreshMu.TryLock() {
+		log.Println("Feed refresh already in progress, skipping this cycle")
+		return nil
+	}
+	defer refreshMu.Unlock()
+
+	feeds, err := load(db)
 	if err != nil {
 		return err
 	}
-	log.Printf("Found %d feeds\n", len(feeds))
+	if len(feeds) == 0 {
+		return nil
+	}
+	log.Printf("Starting %s with %d feeds\n", name, len(feeds))
+	refreshFeeds(db, feeds)
+	log.Printf("Finished %s.", name)
+	return nil
+}
+
+// maxFeedBodySize caps how much of a feed response is read; larger feeds
+// are rejected rather than buffered.
+const maxFeedBodySize = 5 << 20
+
+// readFeedBody reads a feed response, failing rather than returning a
+// truncated body when it is over maxFeedBodySize.
+func readFeedBody(r io.Reader) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r, maxFeedBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxFeedBodySize {
+		return nil, fmt.Errorf("feed is larger than %d MB", maxFeedBodySize>>20)
+	}
+	return body, nil
+}
 
-	fp := gofeed.NewParser()
-	fp.Client = &http.Client{
-		Timeout: 30 * time.Second,
+// fetchResult is the outcome of polling a feed URL.
+type fetchResult struct {
+	StatusCode   int
+	Header       http.Header
+	Body         []byte
+	ETag         string
+	LastModified string
+	ContentHash  string
+	NotModified  bool
+}
+
+func setFeedRequestHeaders(req *http.Request) {
+	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; Suprnews RSS Reader)")
+	req.Header.Set("Accept", "application/rss+xml, application/atom+xml, application/feed+json, application/xml;q=0.9, text/xml;q=0.8, */*;q=0.5")
+}
+
+// fetchFeedDocument downloads a feed, sending the validators from the last
+// successful fetch. NotModified is set when the server answers 304 or the
+// body hashes to the same value as last time.
+func fetchFeedDocument(client *http.Client, feed Feed) (*fetchResult, error) {
+	req, err := http.NewRequest("GET", feed.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	setFeedRequestHeaders(req)
+	if feed.ETag != "" {
+		req.Header.Set("If-None-Match", feed.ETag)
+	}
+	if feed.LastModified != "" {
+		req.Header.Set("If-Modified-Since", feed.LastModified)
 	}
 
-	for _, feed := range feeds {
-		log.Printf("Parsing feed: %s\n", feed.URL)
-		rss, err := fp.ParseURL(feed.URL)
-		if err != nil {
-			log.Printf("Error parsing feed %s: %v", feed.URL, err)
-			continue
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return &fetchResult{
+			StatusCode:   resp.StatusCode,
+			Header:       resp.Header,
+			ETag:         feed.ETag,
+			LastModified: feed.LastModified,
+			ContentHash:  feed.ContentHash,
+			NotModified:  true,
+		}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &httpStatusError{StatusCode: resp.StatusCode, Header: resp.Header}
+	}
+
+	body, err := readFeedBody(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(body)
+	result := &fetchResult{
+		StatusCode:   resp.StatusCode,
+		Header:       resp.Header,
+		Body:         body,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		ContentHash:  hex.EncodeToString(sum[:]),
+	}
+	result.NotModified = feed.ContentHash != "" && result.ContentHash == feed.ContentHash
+	return result, nil
+}
+
+// httpStatusError reports a feed response with an unexpected status code.
+type httpStatusError struct {
+	StatusCode int
+	Header     http.Header
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d", e.StatusCode)
+}
+
+// refreshFeed polls a single feed, stores any new items and schedules the
+// next poll. Unchanged feeds are skipped without parsing.
+func refreshFeed(db *sql.DB, client *http.Client, feed Feed) error {
+	log.Printf("Parsing feed: %s\n", feed.URL)
+	now := time.Now()
+	interval := feed.RefreshInterval
+	if interval <= 0 {
+		interval = feedDefaultInterval
+	}
+
+	result, err := fetchFeedDocument(client, feed)
+	if err != nil {
+		var hints pollingHints
+		status := 0
+		var statusErr *httpStatusError
+		if errors.As(err, &statusErr) {
+			hints = headerHints(statusErr.Header, now)
+			status = statusErr.StatusCode
+		}
+		recordRefreshFailure(db, feed, now, interval, hints, status, err)
+		return err
+	}
+	hints := headerHints(result.Header, now)
+	if result.NotModified {
+		log.Printf("Feed unchanged, skipping: %s", feed.URL)
+		interval = nextRefreshInterval(interval, 0, hints)
+		scheduleFeed(db, feed, interval, nextCheckTime(now, interval, hints))
+		if err := recordFeedSuccess(db, feed.ID, result.StatusCode); err != nil {
+			log.Printf("Error recording feed health for %s: %v", feed.URL, err)
 		}
+		return updateFeedCacheState(db, feed.ID, result.ETag, result.LastModified, result.ContentHash)
+	}
 
-		for _, item := range rss.Items {
-			log.Printf("Found item: Title=%q Link=%s", item.Title, item.Link)
+	parsed, err := gofeed.NewParser().Parse(bytes.NewReader(result.Body))
+	if err != nil {
+		recordRefreshFailure(db, feed, now, interval, hints, result.StatusCode, err)
+		return err
+	}
+	saveFeedItems(db, feed, parsed.Items)
+	if err := recordFeedSuccess(db, feed.ID, result.StatusCode); err != nil {
+		log.Printf("Error recording feed health for %s: %v", feed.URL, err)
+	}
 
-			pubDate := time.Now()
-			if item.PublishedParsed != nil {
-				pubDate = *item.PublishedParsed
-			}
-			if pubDate.Before(time.Now().AddDate(0, 0, -3)) {
-				continue
-			}
+	docHints := documentHints(parsed, result.Body)
+	hints.TTL, hints.UpdatePeriod = docHints.TTL, docHints.UpdatePeriod
+	interval = nextRefreshInterval(interval, publishingInterval(parsed.Items, now), hints)
+	scheduleFeed(db, feed, interval, nextCheckTime(now, interval, hints))
+	return updateFeedCacheState(db, feed.ID, result.ETag, result.LastModified, result.ContentHash)
+}
 
-			// Check if article exists
-			var exists int
-			err = db.QueryRow("SELECT COUNT(*) FROM articles WHERE url = ?", item.Link).Scan(&exists)
-			if err != nil || exists > 0 {
-				continue
-			}
+// recordRefreshFailure stores a failed poll and schedules the retry with
+// exponential backoff.
+func recordRefreshFailure(db *sql.DB, feed Feed, now time.Time, interval time.Duration, hints pollingHints, status int, fetchErr error) {
+	failures, err := recordFeedFailure(db, feed.ID, status, fetchErr.Error(), feedMaxFailures)
+	if err != nil {
+		log.Printf("Error recording feed health for %s: %v", feed.URL, err)
+		failures = feed.ConsecutiveFailures + 1
+	}
+	if feedMaxFailures > 0 && failures >= feedMaxFailures {
+		log.Printf("[WARN] Disabling feed %s after %d consecutive failures", feed.URL, failures)
+	}
+	next := nextCheckTime(now, backoffInterval(interval, failures), hints)
+	log.Printf("Next check for %s in %s", feed.URL, time.Until(next).Round(time.Second))
+	if err := updateFeedSchedule(db, feed.ID, interval, next); err != nil {
+		log.Printf("Error scheduling feed %s: %v", feed.URL, err)
+	}
+}
 
-			// Determine category for the article
-			var category string
-
-			// First, use any categories provided by the RSS feed
-			if len(item.Categories) > 0 {
-				// Join all categories and try to map them to our standard categories
-				feedCategories := strings.ToLower(strings.Join(item.Categories, " "))
-
-				// Try to map the feed categories to our standard categories
-				if strings.Contains(feedCategories, "tech") {
-					category = "technology"
-				} else if strings.Contains(feedCategories, "polit") {
-					category = "politics"
-				} else if strings.Contains(feedCategories, "sport") {
-					category = "sports"
-				} else if strings.Contains(feedCategories, "business") || strings.Contains(feedCategories, "econ") {
-					category = "business"
-				} else if strings.Contains(feedCategories, "entertain") {
-					category = "entertainment"
-				} else if strings.Contains(feedCategories, "health") {
-					category = "health"
-				} else if strings.Contains(feedCategories, "science") {
-					category = "science"
-				} else {
-					// Use our NLP-based categorization
-					category = categorizeArticle(item.Title + " " + item.Description)
-				}
-			} else {
-				// Check feed name for hints
-				feedNameLower := strings.ToLower(feed.Name)
-				if strings.Contains(feedNameLower, "tech") || strings.Contains(feedNameLower, "digital") {
-					category = "technology"
-				} else if strings.Contains(feedNameLower, "sport") {
-					category = "sports"
-				} else if strings.Contains(feedNameLower, "business") || strings.Contains(feedNameLower, "econ") {
-					category = "business"
-				} else if strings.Contains(feedNameLower, "entertain") || strings.Contains(feedNameLower, "hollywood") {
-					category = "entertainment"
-				} else if strings.Contains(feedNameLower, "health") {
-					category = "health"
-				} else if strings.Contains(feedNameLower, "science") {
-					category = "science"
-				} else if strings.Contains(feedNameLower, "polit") {
-					category = "politics"
-				} else {
-					// Use our NLP-based categorization
-					category = categorizeArticle(item.Title + " " + item.Description)
-				}
-			}
+func scheduleFeed(db *sql.DB, feed Feed, interval time.Duration, next time.Time) {
+	log.Printf("Next check for %s in %s", feed.URL, time.Until(next).Round(time.Second))
+	if err := updateFeedSchedule(db, feed.ID, interval, next); err != nil {
+		log.Printf("Error scheduling feed %s: %v", feed.URL, err)
+	}
+}
+
+// saveFeedItems categorizes and inserts the items that are not stored yet.
+func saveFeedItems(db *sql.DB, feed Feed, items []*gofeed.Item) {
+	biasRatings, err := getBiasRatingMap(db)
+	if err != nil {
+		log.Printf("Error loading bias ratings: %v", err)
+	}
+	policy := feed.Retention.policy()
+	for _, item := range items {
+		log.Printf("Found item: Title=%q Link=%s", item.Title, item.Link)
+
+		// Dates are stored in UTC so that they compare correctly as text
+		// whatever zone the feed used.
+		pubDate := time.Now().UTC()
+		if item.PublishedParsed != nil {
+			pubDate = item.PublishedParsed.UTC()
+		}
+		if policy.tooOldToIngest(pubDate, time.Now()) {
+			continue
+		}
 
-			log.Printf("Categorized article '%s' as '%s'", item.Title, category)
-
-			// Extract the image URL from the RSS feed
-			var imageURL string
-			if item.Image != nil {
-				imageURL = item.Image.URL
-			} else if len(item.Enclosures) > 0 {
-				for _, enclosure := range item.Enclosures {
-					// Check if the enclosure is an image
-					if strings.HasPrefix(enclosure.Type, "image/") {
-						imageURL = enclosure.URL
-						break
-					}
+		// Check if article exists
+		var exists int
+		err := db.QueryRow("SELECT COUNT(*) FROM articles WHERE url = ?", item.Link).Scan(&exists)
+		if err != nil || exists > 0 {
+			continue
+		}
+
+		// Determine category for the article. The categories provided by
+		// the RSS feed, or the feed name when there are none, are mapped
+		// onto ours through their aliases before falling back to NLP
+		var category string
+		if len(item.Categories) > 0 {
+			category = matchCategoryAlias(strings.Join(item.Categories, " "))
+		} else {
+			category = matchCategoryAlias(feed.Name)
+		}
+		if category == "" {
+			category = categorizeArticle(item.Title + " " + item.Description)
+		}
+
+		log.Printf("Categorized article '%s' as '%s'", item.Title, category)
+
+		// Extract the image URL from the RSS feed
+		var imageURL string
+		if item.Image != nil {
+			imageURL = item.Image.URL
+		} else if len(item.Enclosures) > 0 {
+			for _, enclosure := range item.Enclosures {
+				// Check if the enclosure is an image
+				if strings.HasPrefix(enclosure.Type, "image/") {
+					imageURL = enclosure.URL
+					break
 				}
 			}
+		}
 
-			// Insert the article
-			_, err = db.Exec(`
-					INSERT INTO articles (title, summary, url, feed_id, published_at, category, sentiment, bias, image_url)
-					VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
-				`, item.Title, item.Description, item.Link, feed.ID, pubDate, category, "neutral", "neutral", imageURL)
+		summary := sanitizeHTML(item.Description, item.Link)
+		sentimentScore, sentiment := scoreSentiment(articleText(item.Title, summary))
 
-			if err != nil {
-				log.Printf("Error inserting article %s: %v", item.Link, err)
+		bias := resolveArticleBias(biasRatings, feed, item.Link)
+
+		// Insert the article
+		res, err := db.Exec(`
+					INSERT INTO articles (title, summary, url, feed_id, published_at, category, sentiment, sentiment_score, bias, image_url)
+					VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+				`, item.Title, summary, item.Link, feed.ID, pubDate, category, sentiment, sentimentScore, bias, imageURL)
+
+		if err != nil {
+			log.Printf("Error inserting article %s: %v", item.Link, err)
+			continue
+		}
+		forgetAllSidebars()
+		if id, err := res.LastInsertId(); err == nil {
+			entities := extractEntities(articleText(item.Title, summary))
+			if err := saveArticleEntities(db, int(id), entities); err != nil {
+				log.Printf("Error saving entities for %s: %v", item.Link, err)
+			}
+			if err := indexArticle(db, int(id)); err != nil {
+				log.Printf("Error indexing %s for search: %v", item.Link, err)
+			}
+			if err := assignStory(db, int(id), simHash(item.Title, summary), pubDate); err != nil {
+				log.Printf("Error assigning story for %s: %v", item.Link, err)
 			}
+			queueContentExtraction(int(id), item.Link)
 		}
 	}
-	log.Println("Finished parseRSSFeeds.")
-	return nil
 }
 
 // Improved categorization function using NLP
 func categorizeArticle(text string) string {
-	// Define category keyword weights
-	categoryVectors := map[string]map[string]float64{
-		"technology": {
-			"tech": 1.0, "technology": 1.0, "software": 0.9, "hardware": 0.9, "programming": 0.8,
-			"developer": 0.8, "code": 0.7, "app": 0.5, "application": 0.6, "digital": 0.6, "ai": 0.9,
-			"artificial intelligence": 1.0, "machine learning": 0.9, "data": 0.5, "computer": 0.8,
-			"internet": 0.8, "cyber": 0.8, "algorithm": 0.8, "robot": 0.8, "automation": 0.8,
-		},
-		"politics": {
-			"politic": 1.0, "government": 0.9, "election": 0.9, "vote": 0.8, "democracy": 0.9,
-			"congress": 0.9, "senate": 0.9, "parliament": 0.9, "legislation": 0.9,
-			"president": 0.9, "minister": 0.9, "governor": 0.8, "democrat": 0.9, "republican": 0.9,
-			"policy": 0.8, "candidate": 0.8, "campaign": 0.8, "constitution": 0.9, "diplomatic": 0.9,
-			"law": 0.6, "party": 0.7, "administration": 0.8, "foreign affairs": 0.9, "domestic policy": 0.9,
-			"senator": 0.9, "congressman": 0.9, "ballot": 0.9, "lobbying": 0.9,
-			"bipartisan": 0.9, "filibuster": 0.9, "geopolitical": 0.9, "diplomat": 0.9,
-			"referendum": 0.9, "constituency": 0.9, "impeachment": 0.9, "veto": 0.9,
-		},
-		"sports": {
-			"sport": 1.0, "game": 0.8, "match": 0.9, "player": 0.9, "team": 0.9, "athlete": 0.9,
-			"championship": 0.9, "tournament": 0.9, "league": 0.9, "football": 1.0, "soccer": 1.0,
-			"basketball": 1.0, "baseball": 1.0, "tennis": 1.0, "cricket": 1.0, "hockey": 1.0,
-			"olympic": 1.0, "coach": 0.9, "score": 0.9, "win": 0.7, "loss": 0.7, "ipl": 1.0,
-			"goal": 0.8, "stadium": 0.9, "referee": 0.9, "umpire": 0.9, "nba": 1.0,
-			"nfl": 1.0, "mlb": 1.0, "fifa": 1.0, "nhl": 1.0, "pga": 1.0, "ufc": 1.0,
-			"medal": 0.8, "competition": 0.7, "trophy": 0.9, "shot": 0.6,
-			"fan": 0.7, "spectator": 0.8, "goalkeeper": 1.0, "runner": 0.9, "batter": 1.0,
-			"wicket": 1.0, "bowl": 0.7, "draft": 0.7, "rookie": 0.9, "playoff": 1.0,
-			"penalty": 0.7, "offside": 1.0, "batting": 1.0, "bowling": 0.9, "fielding": 0.9,
-			"defense": 0.6, "offense": 0.6, "quarter": 0.6, "inning": 1.0, "pitch": 0.7,
-			"grand slam": 1.0, "formula one": 1.0, "f1": 1.0, "boxing": 1.0, "racing": 0.8,
-			"marathon": 0.9, "touchdown": 1.0, "home run": 1.0, "slam dunk": 1.0, "free throw": 1.0,
-			"hat trick": 1.0, "athletics": 0.9, "gymnastics": 1.0, "swimming": 0.8,
-		},
-		"business": {
-			"business": 1.0, "economy": 1.0, "market": 0.9, "finance": 0.9, "stock": 0.9,
-			"investment": 0.9, "company": 0.8, "industry": 0.9, "trade": 0.9, "commercial": 0.9,
-			"corporate": 0.9, "entrepreneur": 0.9, "startup": 0.9, "profit": 0.9, "revenue": 0.9,
-			"economic": 0.9, "financial": 0.9, "banking": 0.9, "investor": 0.9, "ceo": 0.8,
-		},
-		"entertainment": {
-			"entertain": 1.0, "movie": 1.0, "film": 1.0, "music": 1.0, "concert": 0.9,
-			"celebrity": 0.9, "actor": 0.9, "actress": 0.9, "director": 0.8, "tv": 0.9,
-			"television": 0.9, "show": 0.6, "drama": 0.9, "comedy": 0.9, "hollywood": 1.0,
-			"bollywood": 1.0, "star": 0.8, "singer": 0.9, "album": 0.9, "release": 0.7,
-		},
-		"health": {
-			"health": 1.0, "medical": 1.0, "medicine": 1.0, "doctor": 0.9, "hospital": 0.9,
-			"disease": 0.9, "treatment": 0.9, "cure": 0.9, "patient": 0.9, "therapy": 0.9,
-			"diet": 0.9, "fitness": 0.9, "wellness": 0.9, "virus": 0.9, "pandemic": 0.9,
-			"vaccine": 0.9, "symptom": 0.9, "diagnosis": 0.9, "surgery": 0.9, "prescription": 0.9,
-		},
-		"science": {
-			"science": 1.0, "research": 0.9, "study": 0.8, "discover": 0.9, "experiment": 0.9,
-			"scientist": 1.0, "laboratory": 0.9, "physics": 1.0, "chemistry": 1.0, "biology": 1.0,
-			"astronomy": 1.0, "space": 0.9, "theory": 0.8, "hypothesis": 0.9, "scientific": 1.0,
-			"molecule": 0.9, "atom": 0.9, "quantum": 1.0, "genetic": 0.9, "evolution": 0.9,
-		},
-	}
-
 	// Tokenize and POS-tag the text using prose
 	doc, err := prose.NewDocument(text)
 	if err != nil {
 		log.Printf("Error creating prose document: %v", err)
 		return "other"
 	}
+	tokens := doc.Tokens()
+
+	// Keyword scores are blended with the classifier learned from user
+	// corrections, which gains weight as examples accumulate
+	model := currentCategoryModel()
+	scores := blendCategoryScores(keywordCategoryScores(tokens), model.posterior(categoryWords(tokens)), model.docs)
+	category := topCategory(scores)
+
+	log.Printf("[DEBUG] Categorization scores: %v", scores)
+	log.Printf("[DEBUG] Top category: %s (score: %.2f)", category, scores[category])
+	return category
+}
 
+// keywordCategoryScores sums the keyword weights of the nouns in the text
+// for each category. Keywords of several words, such as "machine learning",
+// match as phrases whatever their parts of speech.
+func keywordCategoryScores(tokens []prose.Token) map[string]float64 {
 	// Scoring map for all categories
 	scores := make(map[string]float64)
+	categories := currentCategories()
 
 	// Lowercased token text
-	for _, tok := range doc.Tokens() {
-		word := strings.ToLower(tok.Text)
-
+	words := make([]string, len(tokens))
+	for i, tok := range tokens {
+		words[i] = strings.ToLower(tok.Text)
+	}
+	for i, tok := range tokens {
 		// Prioritize Nouns (common and proper)
 		if tok.Tag == "NN" || tok.Tag == "NNS" || tok.Tag == "NNP" || tok.Tag == "NNPS" {
-			for category, vector := range categoryVectors {
-				if weight, ok := vector[word]; ok {
-					scores[category] += weight
+			for _, category := range categories {
+				if weight, ok := category.Keywords[words[i]]; ok {
+					scores[category.Slug] += weight
 				}
 			}
 		}
 	}
+	for _, category := range categories {
+		for keyword, weight := range category.Keywords {
+			phrase := strings.Fields(keyword)
+			if len(phrase) < 2 {
+				continue
+			}
+			if n := countPhrase(words, phrase); n > 0 {
+				scores[category.Slug] += weight * float64(n)
+			}
+		}
+	}
+	return scores
+}
+
+// countPhrase counts the occurrences of a run of words in the text's words.
+func countPhrase(words, phrase []string) int {
+	n := 0
+	for i := 0; i+len(phrase) <= len(words); i++ {
+		if slices.Equal(words[i:i+len(phrase)], phrase) {
+			n++
+		}
+	}
+	return n
+}
 
+// topCategory returns the highest scoring category, or "other" when
+// nothing scored.
+func topCategory(scores map[string]float64) string {
 	// Return category with highest score
 	var topCategory string
 	var topScore float64
@@ -250,30 +429,30 @@ func categorizeArticle(text string) string {
 			topCategory = cat
 		}
 	}
-
-	log.Printf("[DEBUG] Categorization scores: %v", scores)
-	log.Printf("[DEBUG] Top category: %s (score: %.2f)", topCategory, topScore)
-
 	if topCategory == "" {
 		return "other"
 	}
 	return topCategory
 }
 
-func fetchArticleContent(urlStr string) string {
+// fetchArticleContent extracts the article body from its page and reports
+// which method produced it.
+func fetchArticleContent(urlStr string) (string, string) {
 	log.Printf("[DEBUG] Starting to fetch article content from: %s", urlStr)
 
 	// First try with readability
 	content := fetchWithReadability(urlStr)
+	method := extractionReadability
 
 	// If content is garbled or not available, try with plain HTML parsing
 	if isBinaryOrGarbled(content) || content == "No content available" {
 		log.Printf("[WARN] Content appears to be garbled, trying fallback method")
 		content = fetchPlainHTML(urlStr)
+		method = extractionPlainHTML
 	}
 
 	log.Printf("[DEBUG] Final content length: %d characters", len(content))
-	return content
+	return content, method
 }
 
 // New function to fetch and process with readability
@@ -397,7 +576,7 @@ func fetchWithReadability(urlStr string) string {
 	}
 
 	// Process the content to remove any remaining problematic characters
-	content := cleanContent(article.Content)
+	content := sanitizeHTML(cleanContent(article.Content), urlStr)
 	log.Printf("[DEBUG] Content length after cleaning: %d", len(content))
 
 	// Do a final check for garbled content
@@ -513,6 +692,9 @@ func fetchPlainHTML(urlStr string) string {
 		return "No content available"
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "No content available"
+	}
 
 	// Read body
 	body, err := io.ReadAll(resp.Body)
@@ -548,7 +730,7 @@ func fetchPlainHTML(urlStr string) string {
 		mainContent, _ = doc.Find("body").Html()
 	}
 
-	return "<div class=\"article-content\">" + mainContent + "</div>"
+	return "<div class=\"article-content\">" + sanitizeHTML(mainContent, urlStr) + "</div>"
 }
 
 // Helper function to detect character encoding
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// trustedEmbedHosts lists the hosts whose iframes survive sanitization.
+// Override with a comma-separated TRUSTED_EMBED_HOSTS.
+var trustedEmbedHosts = parseHostList(getEnvString("TRUSTED_EMBED_HOSTS",
+	"www.youtube.com,youtube.com,www.youtube-nocookie.com,player.vimeo.com"))
+
+// sanitizerAllowedTags maps each allowed element to the attributes it may
+// keep. Elements not listed are unwrapped: their text and allowed children
+// are kept but the tag itself is dropped.
+var sanitizerAllowedTags = map[string][]string{
+	"a":          {"href", "title"},
+	"abbr":       {"title"},
+	"b":          nil,
+	"blockquote": {"cite"},
+	"br":         nil,
+	"caption":    nil,
+	"cite":       nil,
+	"code":       nil,
+	"dd":         nil,
+	"del":        nil,
+	"div":        nil,
+	"dl":         nil,
+	"dt":         nil,
+	"em":         nil,
+	"figcaption": nil,
+	"figure":     nil,
+	"h1":         nil,
+	"h2":         nil,
+	"h3":         nil,
+	"h4":         nil,
+	"h5":         nil,
+	"h6":         nil,
+	"hr":         nil,
+	"i":          nil,
+	"iframe":     {"src", "width", "height", "allowfullscreen"},
+	"img":        {"src", "alt", "title", "width", "height"},
+	"ins":        nil,
+	"li":         nil,
+	"mark":       nil,
+	"ol":         {"start"},
+	"p":          nil,
+	"pre":        nil,
+	"q":          {"cite"},
+	"s":          nil,
+	"small":      nil,
+	"span":       nil,
+	"strong":     nil,
+	"sub":        nil,
+	"sup":        nil,
+	"table":      nil,
+	"tbody":      nil,
+	"td":         {"colspan", "rowspan"},
+	"tfoot":      nil,
+	"th":         {"colspan", "rowspan", "scope"},
+	"thead":      nil,
+	"tr":         nil,
+	"u":          nil,
+	"ul":         nil,
+}
+
+// sanitizerDroppedTags are removed together with everything inside them.
+var sanitizerDroppedTags = map[string]bool{
+	"applet": true, "base": true, "button": true, "embed": true, "form": true,
+	"frame": true, "frameset": true, "head": true, "input": true, "link": true,
+	"math": true, "meta": true, "noscript": true, "object": true, "option": true,
+	"script": true, "select": true, "style": true, "svg": true, "template": true,
+	"textarea": true, "title": true,
+}
+
+// sanitizeHTML reduces untrusted feed or page HTML to an allowlist of
+// formatting elements. Scripts, event handlers, inline styles, classes and
+// unsafe URLs are removed, relative URLs are resolved against baseURL, links are
+// made to open in a new tab without an opener, and iframes are only kept
+// when they point at a trusted embed host.
+func sanitizeHTML(content, baseURL string) string {
+	if strings.TrimSpace(content) == "" {
+		return content
+	}
+	base, _ := url.Parse(baseURL)
+	context := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+	nodes, err := html.ParseFragment(strings.NewReader(content), context)
+	if err != nil {
+		return html.EscapeString(content)
+	}
+	var b strings.Builder
+	for _, n := range nodes {
+		renderSanitized(&b, n, base)
+	}
+	return b.String()
+}
+
+func renderSanitized(b *strings.Builder, n *html.Node, base *url.URL) {
+	switch n.Type {
+	case html.TextNode:
+		b.WriteString(html.EscapeString(n.Data))
+		return
+	case html.ElementNode:
+	default:
+		// Comments, doctypes and the like carry nothing worth keeping.
+		return
+	}
+
+	tag := strings.ToLower(n.Data)
+	if sanitizerDroppedTags[tag] {
+		return
+	}
+	allowedAttrs, ok := sanitizerAllowedTags[tag]
+	if !ok {
+		renderSanitizedChildren(b, n, base)
+		return
+	}
+
+	attrs, ok := sanitizeAttributes(tag, n.Attr, allowedAttrs, base)
+	if !ok {
+		return
+	}
+	b.WriteString("<" + tag)
+	for _, a := range attrs {
+		b.WriteString(" " + a.Key + `="` + html.EscapeString(a.Val) + `"`)
+	}
+	b.WriteString(">")
+	if tag == "br" || tag == "hr" || tag == "img" {
+		return
+	}
+	if tag != "iframe" {
+		renderSanitizedChildren(b, n, base)
+	}
+	b.WriteString("</" + tag + ">")
+}
+
+func renderSanitizedChildren(b *strings.Builder, n *html.Node, base *url.URL) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		renderSanitized(b, c, base)
+	}
+}
+
+// sanitizeAttributes keeps the allowed attributes of an element and adds the
+// ones we enforce. It reports false when the element must be dropped, such
+// as an image without a usable source or an iframe from an untrusted host.
+func sanitizeAttributes(tag string, attrs []html.Attribute, allowed []string, base *url.URL) ([]html.Attribute, bool) {
+	var out []html.Attribute
+	for _, a := range attrs {
+		key := strings.ToLower(a.Key)
+		if a.Namespace != "" {
+			continue
+		}
+		if !containsString(allowed, key) {
+			continue
+		}
+		switch key {
+		case "href", "src", "cite":
+			safe, ok := sanitizeURL(a.Val, base, key == "href")
+			if !ok {
+				continue
+			}
+			out = append(out, html.Attribute{Key: key, Val: safe})
+		default:
+			out = append(out, html.Attribute{Key: key, Val: a.Val})
+		}
+	}
+
+	switch tag {
+	case "a":
+		out = append(out,
+			html.Attribute{Key: "target", Val: "_blank"},
+			html.Attribute{Key: "rel", Val: "noopener noreferrer nofollow"})
+	case "img":
+		if !hasAttribute(out, "src") {
+			return nil, false
+		}
+		out = append(out, html.Attribute{Key: "loading", Val: "lazy"})
+	case "iframe":
+		src := attributeValue(out, "src")
+		u, err := url.Parse(src)
+		if src == "" || err != nil || u.Scheme != "https" || !trustedEmbedHosts[strings.ToLower(u.Hostname())] {
+			return nil, false
+		}
+		out = append(out,
+			html.Attribute{Key: "sandbox", Val: "allow-scripts allow-same-origin allow-presentation allow-popups"},
+			html.Attribute{Key: "referrerpolicy", Val: "strict-origin-when-cross-origin"})
+	}
+	return out, true
+}
+
+// unreadableNotice stands in for a summary that cannot be displayed. The
+// article URL comes from the feed, so it is escaped and goes through the
+// same checks as any other link.
+func unreadableNotice(articleURL string) string {
+	return sanitizeHTML(`<p>Content couldn't be properly displayed. <a href="`+html.EscapeString(articleURL)+
+		`">View the original article</a>.</p>`, "")
+}
+
+// sanitizeURL resolves a URL attribute and rejects anything but http(s),
+// plus mailto for links. Browsers ignore embedded whitespace and control
+// characters in schemes, so those are stripped before checking.
+func sanitizeURL(raw string, base *url.URL, allowMailto bool) (string, bool) {
+	cleaned := strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, raw)
+	if cleaned == "" {
+		return "", false
+	}
+	u, err := url.Parse(cleaned)
+	if err != nil {
+		return "", false
+	}
+	if base != nil && !u.IsAbs() {
+		u = base.ResolveReference(u)
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		return u.String(), true
+	case "mailto":
+		return u.String(), allowMailto
+	case "":
+		// Relative URL with no base to resolve it against.
+		return u.String(), true
+	default:
+		return "", false
+	}
+}
+
+// plainText returns the visible text of an HTML fragment with runs of
+// whitespace collapsed, for scoring and indexing rather than display.
+func plainText(content string) string {
+	z := html.NewTokenizer(strings.NewReader(content))
+	var b strings.Builder
+	skip := 0
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return strings.Join(strings.Fields(b.String()), " ")
+		case html.StartTagToken:
+			name, _ := z.TagName()
+			if sanitizerDroppedTags[string(name)] {
+				skip++
+			}
+			b.WriteByte(' ')
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			if sanitizerDroppedTags[string(name)] && skip > 0 {
+				skip--
+			}
+			b.WriteByte(' ')
+		case html.TextToken:
+			if skip == 0 {
+				b.Write(z.Text())
+			}
+		}
+	}
+}
+
+// articleText is the text an article is analysed on: the headline and the
+// plain text of its feed summary.
+func articleText(title, summary string) string {
+	return title + ". " + plainText(summary)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAttribute(attrs []html.Attribute, key string) bool {
+	return attributeValue(attrs, key) != ""
+}
+
+func attributeValue(attrs []html.Attribute, key string) string {
+	for _, a := range attrs {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func parseHostList(list string) map[string]bool {
+	hosts := make(map[string]bool)
+	for _, h := range strings.Split(list, ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			hosts[h] = true
+		}
+	}
+	return hosts
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const linkAttrs = ` target="_blank" rel="noopener noreferrer nofollow"`
+
+const embedAttrs = ` sandbox="allow-scripts allow-same-origin allow-presentation allow-popups" referrerpolicy="strict-origin-when-cross-origin"`
+
+// sanitizeCorpus is a regression corpus of XSS payloads and the markup that
+// must survive them. Add a case for every bypass found.
+var sanitizeCorpus = []struct {
+	name string
+	in   string
+	want string
+}{
+	// Scripts and event handlers.
+	{"script element", `<p>Hello <script>alert(1)</script>world</p>`, `<p>Hello world</p>`},
+	{"uppercase script", `<SCRIPT SRC=https://x.example/x.js></SCRIPT>ok`, `ok`},
+	{"onerror attribute", `<img src="https://x.example/a.png" onerror="alert(1)">`, `<img src="https://x.example/a.png" loading="lazy">`},
+	{"onclick attribute", `<a href="https://x.example/" onclick="alert(1)">link</a>`, `<a href="https://x.example/"` + linkAttrs + `>link</a>`},
+	{"onload on iframe", `<iframe src="https://www.youtube.com/embed/abc" onload="alert(1)"></iframe>`, `<iframe src="https://www.youtube.com/embed/abc"` + embedAttrs + `></iframe>`},
+	{"img without src", `<img src=x onerror=alert(1)>`, `<img src="https://news.example/x" loading="lazy">`},
+
+	// Dangerous URL schemes, plain, mixed-case and entity-encoded.
+	{"javascript href", `<a href="javascript:alert(1)">x</a>`, `<a` + linkAttrs + `>x</a>`},
+	{"mixed-case javascript", `<a href="JaVaScRiPt:alert(1)">x</a>`, `<a` + linkAttrs + `>x</a>`},
+	{"decimal entity javascript", `<a href="&#106;avascript:alert(1)">x</a>`, `<a` + linkAttrs + `>x</a>`},
+	{"hex entity javascript", `<a href="&#x6A;&#x61;&#x76;&#x61;&#x73;&#x63;&#x72;&#x69;&#x70;&#x74;&#x3A;alert(1)">x</a>`, `<a` + linkAttrs + `>x</a>`},
+	{"tab inside scheme", `<a href="jav&#x09;ascript:alert(1)">x</a>`, `<a` + linkAttrs + `>x</a>`},
+	{"newline inside scheme", "<a href=\"java\nscript:alert(1)\">x</a>", `<a` + linkAttrs + `>x</a>`},
+	{"leading space", `<a href=" javascript:alert(1)">x</a>`, `<a` + linkAttrs + `>x</a>`},
+	{"data image", `<img src="data:image/svg+xml;base64,PHN2Zz48L3N2Zz4=">`, ``},
+	{"uppercase data link", `<a href="DATA:text/html,<script>alert(1)</script>">x</a>`, `<a` + linkAttrs + `>x</a>`},
+	{"vbscript", `<a href="vbscript:msgbox(1)">x</a>`, `<a` + linkAttrs + `>x</a>`},
+	{"named entity colon vbscript", `<a href="VbScRiPt&colon;msgbox(1)">x</a>`, `<a` + linkAttrs + `>x</a>`},
+	{"quote breaking out of href", `<a href='https://x.example/'onmouseover='alert(1)'>x</a>`, `<a href="https://x.example/"` + linkAttrs + `>x</a>`},
+	{"javascript blockquote cite", `<blockquote cite="javascript:alert(1)">q</blockquote>`, `<blockquote>q</blockquote>`},
+
+	// Foreign content and styles.
+	{"svg with script", `<svg onload="alert(1)"><script>alert(1)</script><a href="https://x.example/">x</a></svg>after`, `after`},
+	{"math mglyph style", `<math><mtext><table><mglyph><style><img src=x onerror=alert(1)>`, ``},
+	{"svg style", `<svg><style><img src=x onerror=alert(1)></style></svg>`, ``},
+	{"style element", `<style>body{display:none}</style><p>text</p>`, `<p>text</p>`},
+	{"style attribute", `<p style="position:fixed">x</p>`, `<p>x</p>`},
+	{"class attribute", `<div class="fixed inset-0 z-50">overlay</div>`, `<div>overlay</div>`},
+	{"noscript breakout", `<noscript><p title="</noscript><img src=x onerror=alert(1)>"></noscript>`, `<img src="https://news.example/x" loading="lazy">&#34;&gt;`},
+
+	// Embeds.
+	{"iframe untrusted host", `<iframe src="https://evil.example/embed"></iframe>`, ``},
+	{"iframe over http", `<iframe src="http://www.youtube.com/embed/abc"></iframe>`, ``},
+	{"iframe lookalike host", `<iframe src="https://www.youtube.com.evil.example/embed/abc"></iframe>`, ``},
+	{"iframe javascript", `<iframe src="javascript:alert(1)"></iframe>`, ``},
+	{"iframe protocol-relative", `<iframe src="//www.youtube.com/embed/abc"></iframe>`, `<iframe src="https://www.youtube.com/embed/abc"` + embedAttrs + `></iframe>`},
+	{"object and embed", `<object data="https://x.example/x.swf"><embed src="https://x.example/x.swf"></object>ok`, `ok`},
+
+	// Markup that must survive.
+	{"relative link resolved", `<a href="/story">rel</a>`, `<a href="https://news.example/story"` + linkAttrs + `>rel</a>`},
+	{"mailto link", `<a href="mailto:desk@news.example">mail</a>`, `<a href="mailto:desk@news.example"` + linkAttrs + `>mail</a>`},
+	{"unknown element unwrapped", `<custom-tag><b>bold</b></custom-tag>`, `<b>bold</b>`},
+	{"text escaped", `1 &lt; 2 &amp; 3`, `1 &lt; 2 &amp; 3`},
+}
+
+func TestSanitizeHTML(t *testing.T) {
+	for _, tc := range sanitizeCorpus {
+		t.Run(tc.name, func(t *testing.T) {
+			got := sanitizeHTML(tc.in, "https://news.example/post")
+			if got != tc.want {
+				t.Errorf("sanitizeHTML(%q)\n got %q\nwant %q", tc.in, got, tc.want)
+			}
+		})
+	}
+}
+
+// TestSanitizeHTMLIdempotent checks that sanitized output passes through
+// again unchanged, so stored content can be re-sanitized safely.
+func TestSanitizeHTMLIdempotent(t *testing.T) {
+	for _, tc := range sanitizeCorpus {
+		once := sanitizeHTML(tc.in, "https://news.example/post")
+		if twice := sanitizeHTML(once, "https://news.example/post"); twice != once {
+			t.Errorf("%s: second pass changed %q to %q", tc.name, once, twice)
+		}
+	}
+}
+
+func TestSanitizeHTMLNoActiveContent(t *testing.T) {
+	for _, tc := range sanitizeCorpus {
+		got := strings.ToLower(sanitizeHTML(tc.in, "https://news.example/post"))
+		for _, bad := range []string{"<script", "<svg", "<math", "<style", "javascript:", "vbscript:", "data:", " on", "class="} {
+			if strings.Contains(got, bad) {
+				t.Errorf("%s: output %q contains %q", tc.name, got, bad)
+			}
+		}
+	}
+}
+
+func TestUnreadableNotice(t *testing.T) {
+	const text = `<p>Content couldn&#39;t be properly displayed. <a`
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://news.example/story", text + ` href="https://news.example/story"` + linkAttrs + `>View the original article</a>.</p>`},
+		{"https://x.example/'onmouseover='alert(1)", text + ` href="https://x.example/&#39;onmouseover=&#39;alert(1)"` + linkAttrs + `>View the original article</a>.</p>`},
+		{`https://x.example/"><script>alert(1)</script>`, text + ` href="https://x.example/%22%3E%3Cscript%3Ealert%281%29%3C/script%3E"` + linkAttrs + `>View the original article</a>.</p>`},
+		{"javascript:alert(1)", text + linkAttrs + `>View the original article</a>.</p>`},
+	}
+	for _, tc := range tests {
+		if got := unreadableNotice(tc.url); got != tc.want {
+			t.Errorf("unreadableNotice(%q)\n got %q\nwant %q", tc.url, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SavedSearch is a search a user keeps under a name and opens like a feed.
+type SavedSearch struct {
+	ID           int
+	Name         string
+	Params       string // the search page parameters, URL-encoded
+	LastViewedAt time.Time
+	Unread       int // matches fetched since LastViewedAt
+}
+
+// Query returns the words and filters typed in the search box.
+func (s SavedSearch) Query() string {
+	params, _ := url.ParseQuery(s.Params)
+	return params.Get("q")
+}
+
+// SearchURL links to the search page showing the saved search.
+func (s SavedSearch) SearchURL() string {
+	return "/search?" + s.Params
+}
+
+// search returns the words and filter of the saved search, limited to
+// articles fetched after since unless it is zero.
+func (s SavedSearch) search(since time.Time) (string, ArticleFilter) {
+	params, _ := url.ParseQuery(s.Params)
+	text, filter := searchFromParams(params)
+	filter.AddedAfter = since
+	return text, filter
+}
+
+// getSavedSearches returns the user's saved searches by name, each with the
+// number of matches fetched since it was last opened.
+func getSavedSearches(db *sql.DB, userID int) ([]SavedSearch, error) {
+	rows, err := db.Query(`
+		SELECT id, name, params, last_viewed_at
+		FROM saved_searches
+		WHERE user_id = ?
+		ORDER BY name COLLATE NOCASE
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	var searches []SavedSearch
+	for rows.Next() {
+		var s SavedSearch
+		if err := rows.Scan(&s.ID, &s.Name, &s.Params, &s.LastViewedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		searches = append(searches, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i, s := range searches {
+		text, filter := s.search(s.LastViewedAt)
+		if searches[i].Unread, err = countSearchMatches(db, userID, text, filter); err != nil {
+			return nil, err
+		}
+	}
+	return searches, nil
+}
+
+func getSavedSearch(db *sql.DB, userID, id int) (SavedSearch, error) {
+	var s SavedSearch
+	err := db.QueryRow(`
+		SELECT id, name, params, last_viewed_at
+		FROM saved_searches
+		WHERE id = ? AND user_id = ?
+	`, id, userID).Scan(&s.ID, &s.Name, &s.Params, &s.LastViewedAt)
+	return s, err
+}
+
+// saveSearch stores the search under the name, replacing the user's search
+// of the same name, and returns its ID.
+func saveSearch(db *sql.DB, userID int, name, params string) (int, error) {
+	defer forgetSidebar(userID)
+	_, err := db.Exec(`
+		INSERT INTO saved_searches (user_id, name, params, last_viewed_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (user_id, name) DO UPDATE SET params = excluded.params
+	`, userID, name, params, time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	var id int
+	err = db.QueryRow("SELECT id FROM saved_searches WHERE user_id = ? AND name = ?", userID, name).Scan(&id)
+	return id, err
+}
+
+func deleteSavedSearch(db *sql.DB, userID, id int) error {
+	defer forgetSidebar(userID)
+	res, err := db.Exec("DELETE FROM saved_searches WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func markSavedSearchViewed(db *sql.DB, id int, viewedAt time.Time) error {
+	_, err := db.Exec("UPDATE saved_searches SET last_viewed_at = ? WHERE id = ?", viewedAt.UTC(), id)
+	return err
+}
+
+// saveSearchHandler saves the search the user is looking at under a name.
+func saveSearchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimSpace(r.FormValue("name"))
+	params, err := url.ParseQuery(r.FormValue("params"))
+	if name == "" || err != nil {
+		http.Error(w, "A name and a search are required", http.StatusBadRequest)
+		return
+	}
+	params.Del("page")
+	if text, filter := searchFromParams(params); text == "" && filter == (ArticleFilter{}) {
+		http.Error(w, "A name and a search are required", http.StatusBadRequest)
+		return
+	}
+	id, err := saveSearch(db, currentUser(r).ID, name, params.Encode())
+	if err != nil {
+		http.Error(w, "Failed to save search: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/searches/"+strconv.Itoa(id), http.StatusSeeOther)
+}
+
+// savedSearchHandler lists the matches of a saved search newest first, like
+// a feed, marking the ones fetched since the user last opened it.
+func savedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Path[len("/searches/"):])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	user := currentUser(r)
+	saved, err := getSavedSearch(db, user.ID, id)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("[ERROR] Error getting saved search: %v", err)
+		}
+		http.NotFound(w, r)
+		return
+	}
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	// Later pages keep highlighting what was new when the first was opened.
+	since := saved.LastViewedAt
+	if unix, err := strconv.ParseInt(r.URL.Query().Get("since"), 10, 64); err == nil {
+		since = time.Unix(unix, 0)
+	}
+
+	text, filter := saved.search(time.Time{})
+	articles, total, err := searchArticles(db, user.ID, text, filter, searchByDate, searchPageSize, (page-1)*searchPageSize)
+	if err != nil {
+		log.Printf("[ERROR] Error running saved search: %v", err)
+		http.Error(w, "Failed to search articles", http.StatusInternalServerError)
+		return
+	}
+	for i := range articles {
+		articles[i].New = articles[i].CreatedAt.After(since)
+	}
+	if err := attachArticleEntities(db, articles); err != nil {
+		log.Printf("[ERROR] Error getting article entities: %v", err)
+	}
+	if err := markSavedSearchViewed(db, saved.ID, time.Now()); err != nil {
+		log.Printf("[ERROR] Error marking saved search viewed: %v", err)
+	}
+	forgetSidebar(user.ID)
+
+	feeds, err := getFeeds(db, user.ID)
+	if err != nil {
+		log.Printf("[ERROR] Error getting feeds: %v", err)
+		http.Error(w, "Failed to load feeds", http.StatusInternalServerError)
+		return
+	}
+
+	renderTemplate(w, r, "index.html", PageData{
+		Articles:    articles,
+		Feeds:       feeds,
+		Active:      "saved-" + strconv.Itoa(saved.ID),
+		Query:       saved.Query(),
+		Count:       total,
+		Filters:     filter,
+		Page:        page,
+		TotalPages:  (total + searchPageSize - 1) / searchPageSize,
+		PageURL:     "/searches/" + strconv.Itoa(saved.ID) + "?since=" + strconv.FormatInt(since.Unix(), 10) + "&page=",
+		SavedSearch: &saved,
+	})
+}
+
+func deleteSavedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.Atoi(r.URL.Path[len("/searches/delete/"):])
+	if err != nil {
+		http.Error(w, "Invalid saved search ID", http.StatusBadRequest)
+		return
+	}
+	if err := deleteSavedSearch(db, currentUser(r).ID, id); err != nil {
+		if err == sql.ErrNoRows {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "Failed to delete saved search: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
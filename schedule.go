@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/mmcdole/gofeed/rss"
+)
+
+// Polling schedule bounds, overridable through the environment.
+var (
+	feedMinInterval     = getEnvDuration("FEED_MIN_INTERVAL", 5*time.Minute)
+	feedMaxInterval     = getEnvDuration("FEED_MAX_INTERVAL", 24*time.Hour)
+	feedDefaultInterval = getEnvDuration("FEED_DEFAULT_INTERVAL", 30*time.Minute)
+	schedulerTick       = getEnvDuration("FEED_SCHEDULER_TICK", time.Minute)
+	feedMaxBackoff      = getEnvDuration("FEED_MAX_BACKOFF", 24*time.Hour)
+	feedMaxFailures     = getEnvInt("FEED_MAX_FAILURES", 10)
+)
+
+// pollingHints collects what a publisher tells us about how often a feed
+// should be polled.
+type pollingHints struct {
+	TTL          time.Duration // RSS <ttl>
+	UpdatePeriod time.Duration // sy:updatePeriod divided by sy:updateFrequency
+	MaxAge       time.Duration // Cache-Control max-age
+	RetryAfter   time.Duration // Retry-After
+}
+
+// headerHints reads Cache-Control and Retry-After from a feed response.
+func headerHints(h http.Header, now time.Time) pollingHints {
+	var hints pollingHints
+	for _, directive := range strings.Split(h.Get("Cache-Control"), ",") {
+		directive = strings.TrimSpace(strings.ToLower(directive))
+		if v, ok := strings.CutPrefix(directive, "max-age="); ok {
+			if secs, err := strconv.Atoi(strings.Trim(v, `"`)); err == nil && secs > 0 {
+				hints.MaxAge = time.Duration(secs) * time.Second
+			}
+		}
+	}
+	if v := strings.TrimSpace(h.Get("Retry-After")); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+			hints.RetryAfter = time.Duration(secs) * time.Second
+		} else if t, err := http.ParseTime(v); err == nil && t.After(now) {
+			hints.RetryAfter = t.Sub(now)
+		}
+	}
+	return hints
+}
+
+// documentHints reads <ttl> and the syndication module elements from a
+// parsed feed. The raw body is needed because gofeed drops <ttl> when
+// translating RSS into its universal format.
+func documentHints(parsed *gofeed.Feed, body []byte) pollingHints {
+	var hints pollingHints
+	if parsed.FeedType == "rss" {
+		if doc, err := (&rss.Parser{}).Parse(bytes.NewReader(body)); err == nil {
+			if minutes, err := strconv.Atoi(strings.TrimSpace(doc.TTL)); err == nil && minutes > 0 {
+				hints.TTL = time.Duration(minutes) * time.Minute
+			}
+		}
+	}
+	if sy, ok := parsed.Extensions["sy"]; ok {
+		var period time.Duration
+		if v := sy["updatePeriod"]; len(v) > 0 {
+			switch strings.ToLower(strings.TrimSpace(v[0].Value)) {
+			case "hourly":
+				period = time.Hour
+			case "daily":
+				period = 24 * time.Hour
+			case "weekly":
+				period = 7 * 24 * time.Hour
+			case "monthly":
+				period = 30 * 24 * time.Hour
+			case "yearly":
+				period = 365 * 24 * time.Hour
+			}
+		}
+		frequency := 1
+		if v := sy["updateFrequency"]; len(v) > 0 {
+			if n, err := strconv.Atoi(strings.TrimSpace(v[0].Value)); err == nil && n > 0 {
+				frequency = n
+			}
+		}
+		if period > 0 {
+			hints.UpdatePeriod = period / time.Duration(frequency)
+		}
+	}
+	return hints
+}
+
+// publishingInterval estimates how often a feed publishes from the dates of
+// its newest items. A feed that has gone quiet since its last item is
+// treated as publishing at least that rarely. Zero means unknown.
+func publishingInterval(items []*gofeed.Item, now time.Time) time.Duration {
+	var dates []time.Time
+	for _, item := range items {
+		if item.PublishedParsed != nil {
+			dates = append(dates, *item.PublishedParsed)
+		} else if item.UpdatedParsed != nil {
+			dates = append(dates, *item.UpdatedParsed)
+		}
+	}
+	if len(dates) < 2 {
+		return 0
+	}
+	sort.Slice(dates, func(i, j int) bool { return dates[i].After(dates[j]) })
+	if len(dates) > 10 {
+		dates = dates[:10]
+	}
+	gap := dates[0].Sub(dates[len(dates)-1]) / time.Duration(len(dates)-1)
+	if since := now.Sub(dates[0]); since > gap {
+		gap = since
+	}
+	return gap
+}
+
+// nextRefreshInterval picks the polling interval for a feed: half its
+// observed publishing interval, never below what the publisher asks for,
+// clamped to the configured bounds.
+func nextRefreshInterval(current, publishing time.Duration, hints pollingHints) time.Duration {
+	interval := current
+	if interval <= 0 {
+		interval = feedDefaultInterval
+	}
+	if publishing > 0 {
+		interval = publishing / 2
+	}
+	for _, floor := range []time.Duration{hints.TTL, hints.UpdatePeriod, hints.MaxAge} {
+		if floor > interval {
+			interval = floor
+		}
+	}
+	if interval < feedMinInterval {
+		interval = feedMinInterval
+	}
+	if interval > feedMaxInterval {
+		interval = feedMaxInterval
+	}
+	return interval
+}
+
+// nextCheckTime returns when a feed should next be polled, honoring any
+// Retry-After the server sent.
+func nextCheckTime(now time.Time, interval time.Duration, hints pollingHints) time.Time {
+	next := now.Add(interval)
+	if retry := now.Add(hints.RetryAfter); retry.After(next) {
+		next = retry
+	}
+	return next
+}
+
+// backoffInterval doubles the polling interval for every consecutive
+// failure, up to the configured maximum backoff.
+func backoffInterval(interval time.Duration, failures int) time.Duration {
+	for i := 1; i < failures && interval < feedMaxBackoff; i++ {
+		interval *= 2
+	}
+	if interval > feedMaxBackoff {
+		interval = feedMaxBackoff
+	}
+	return interval
+}
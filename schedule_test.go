@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+func itemsPublished(now time.Time, agos ...time.Duration) []*gofeed.Item {
+	var items []*gofeed.Item
+	for _, ago := range agos {
+		t := now.Add(-ago)
+		items = append(items, &gofeed.Item{PublishedParsed: &t})
+	}
+	return items
+}
+
+func TestPublishingInterval(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	updated := now.Add(-2 * time.Hour)
+	tests := []struct {
+		name  string
+		items []*gofeed.Item
+		want  time.Duration
+	}{
+		{"no items", nil, 0},
+		{"one item", itemsPublished(now, time.Hour), 0},
+		{"undated items", []*gofeed.Item{{}, {}}, 0},
+		{"hourly", itemsPublished(now, 0, time.Hour, 2*time.Hour, 3*time.Hour), time.Hour},
+		{"unordered", itemsPublished(now, 2*time.Hour, 0, 3*time.Hour, time.Hour), time.Hour},
+		{"quiet since last item", itemsPublished(now, 10*time.Hour, 11*time.Hour, 12*time.Hour), 10 * time.Hour},
+		{"updated date used", append(itemsPublished(now, 0), &gofeed.Item{UpdatedParsed: &updated}), 2 * time.Hour},
+		{
+			"only newest ten items",
+			itemsPublished(now, 0, time.Minute, 2*time.Minute, 3*time.Minute, 4*time.Minute,
+				5*time.Minute, 6*time.Minute, 7*time.Minute, 8*time.Minute, 9*time.Minute, 1000*time.Hour),
+			time.Minute,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := publishingInterval(tc.items, now); got != tc.want {
+				t.Errorf("publishingInterval = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNextRefreshInterval(t *testing.T) {
+	tests := []struct {
+		name       string
+		current    time.Duration
+		publishing time.Duration
+		hints      pollingHints
+		want       time.Duration
+	}{
+		{"no history uses default", 0, 0, pollingHints{}, feedDefaultInterval},
+		{"no history keeps current", 2 * time.Hour, 0, pollingHints{}, 2 * time.Hour},
+		{"half the publishing interval", 0, 4 * time.Hour, pollingHints{}, 2 * time.Hour},
+		{"ttl is a floor", 0, 4 * time.Hour, pollingHints{TTL: 3 * time.Hour}, 3 * time.Hour},
+		{"update period is a floor", 0, time.Hour, pollingHints{UpdatePeriod: 6 * time.Hour}, 6 * time.Hour},
+		{"max-age is a floor", 0, time.Hour, pollingHints{MaxAge: 45 * time.Minute}, 45 * time.Minute},
+		{"short hint ignored", 0, 4 * time.Hour, pollingHints{TTL: time.Minute}, 2 * time.Hour},
+		{"clamped to minimum", 0, time.Minute, pollingHints{}, feedMinInterval},
+		{"clamped to maximum", 0, 30 * 24 * time.Hour, pollingHints{}, feedMaxInterval},
+		{"hint clamped to maximum", 0, 0, pollingHints{TTL: 7 * 24 * time.Hour}, feedMaxInterval},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := nextRefreshInterval(tc.current, tc.publishing, tc.hints); got != tc.want {
+				t.Errorf("nextRefreshInterval = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestHeaderHints(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		header http.Header
+		want   pollingHints
+	}{
+		{"none", http.Header{}, pollingHints{}},
+		{"max-age", http.Header{"Cache-Control": {"public, max-age=600"}}, pollingHints{MaxAge: 10 * time.Minute}},
+		{"zero max-age", http.Header{"Cache-Control": {"max-age=0"}}, pollingHints{}},
+		{"retry-after seconds", http.Header{"Retry-After": {"120"}}, pollingHints{RetryAfter: 2 * time.Minute}},
+		{"retry-after date", http.Header{"Retry-After": {now.Add(time.Hour).Format(http.TimeFormat)}}, pollingHints{RetryAfter: time.Hour}},
+		{"retry-after in the past", http.Header{"Retry-After": {now.Add(-time.Hour).Format(http.TimeFormat)}}, pollingHints{}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := headerHints(tc.header, now); got != tc.want {
+				t.Errorf("headerHints = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNextCheckTimeHonorsRetryAfter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if got := nextCheckTime(now, time.Hour, pollingHints{}); !got.Equal(now.Add(time.Hour)) {
+		t.Errorf("without Retry-After: %v", got)
+	}
+	if got := nextCheckTime(now, time.Hour, pollingHints{RetryAfter: 3 * time.Hour}); !got.Equal(now.Add(3 * time.Hour)) {
+		t.Errorf("with Retry-After: %v", got)
+	}
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"html"
+	"html/template"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const searchPageSize = 20
+
+// Matches in snippets are wrapped in these markers by SQLite and turned into
+// <mark> tags once the rest of the snippet has been escaped.
+const (
+	searchMarkStart = "\x02"
+	searchMarkEnd   = "\x03"
+)
+
+// checkSearchSupport fails unless SQLite was built with FTS5, which is off
+// in go-sqlite3 by default. Without it a database that already has the
+// search index cannot even be opened.
+func checkSearchSupport(db *sql.DB) error {
+	var enabled bool
+	if err := db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&enabled); err != nil {
+		return err
+	}
+	if !enabled {
+		return errors.New("search needs SQLite with FTS5, build with -tags sqlite_fts5")
+	}
+	return nil
+}
+
+// createSearchIndex creates the full-text index of articles. Rows are
+// indexed as plain text by indexArticle and dropped with their article by a
+// trigger.
+func createSearchIndex(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE VIRTUAL TABLE IF NOT EXISTS articles_fts USING fts5(
+			title, summary, content,
+			tokenize = 'porter unicode61 remove_diacritics 2'
+		);
+		CREATE TRIGGER IF NOT EXISTS articles_fts_delete AFTER DELETE ON articles BEGIN
+			DELETE FROM articles_fts WHERE rowid = old.id;
+		END;
+	`)
+	return err
+}
+
+// indexArticle stores the current title, summary and extracted content of
+// an article in the search index.
+func indexArticle(db *sql.DB, articleID int) error {
+	var title, summary, content string
+	err := db.QueryRow("SELECT title, IFNULL(summary, ''), IFNULL(content, '') FROM articles WHERE id = ?", articleID).
+		Scan(&title, &summary, &content)
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM articles_fts WHERE rowid = ?", articleID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO articles_fts (rowid, title, summary, content) VALUES (?, ?, ?, ?)",
+		articleID, title, plainText(summary), plainText(content)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// rebuildSearchIndex indexes every stored article from scratch.
+func rebuildSearchIndex(db *sql.DB) (int, error) {
+	rows, err := db.Query("SELECT id FROM articles")
+	if err != nil {
+		return 0, err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if _, err := db.Exec("DELETE FROM articles_fts"); err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		if err := indexArticle(db, id); err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), nil
+}
+
+// Orders of search results.
+const (
+	searchByRelevance = "relevance"
+	searchByDate      = "date"
+)
+
+// searchArticles returns the user's articles matching the query and filter,
+// skipping offset matches and returning at most limit, along with the total
+// number of matches. Matches of a query carry a snippet of the text around
+// the matched words. Without a query the results are always newest first.
+func searchArticles(db *sql.DB, userID int, query string, filter ArticleFilter, order string, limit, offset int) ([]Article, int, error) {
+	match := ftsQuery(query)
+	if match == "" && filter == (ArticleFilter{}) {
+		return nil, 0, nil
+	}
+	from, args := searchSource(userID, match, filter)
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*)"+from, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	snippet := "''"
+	orderBy := "a.published_at DESC"
+	if match != "" {
+		snippet = "snippet(articles_fts, -1, ?, ?, '…', 24)"
+		args = append([]interface{}{searchMarkStart, searchMarkEnd}, args...)
+		if order == searchByRelevance {
+			orderBy = "bm25(articles_fts, 10.0, 3.0, 1.0)"
+		}
+	}
+	rows, err := db.Query(`
+		SELECT `+articleListColumns+`, `+snippet+from+`
+		ORDER BY `+orderBy+`
+		LIMIT ? OFFSET ?
+	`, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	var articles []Article
+	for rows.Next() {
+		var a Article
+		var snippet string
+		if err := rows.Scan(append(articleFields(&a), &snippet)...); err != nil {
+			return nil, 0, err
+		}
+		fillEmptySummary(&a)
+		if snippet != "" {
+			a.Snippet = highlightSnippet(snippet)
+		}
+		articles = append(articles, a)
+	}
+	return articles, total, rows.Err()
+}
+
+// countSearchMatches returns the number of the user's articles matching the
+// query and filter.
+func countSearchMatches(db *sql.DB, userID int, query string, filter ArticleFilter) (int, error) {
+	match := ftsQuery(query)
+	if match == "" && filter == (ArticleFilter{}) {
+		return 0, nil
+	}
+	from, args := searchSource(userID, match, filter)
+	var total int
+	err := db.QueryRow("SELECT COUNT(*)"+from, args...).Scan(&total)
+	return total, err
+}
+
+// searchSource returns the FROM and WHERE clauses selecting the user's
+// articles that match the FTS5 expression, if any, and the filter.
+func searchSource(userID int, match string, filter ArticleFilter) (string, []interface{}) {
+	conditions, filterArgs := filter.conditions()
+	subscribed := "JOIN subscriptions s ON s.feed_id = a.feed_id AND s.user_id = ?"
+	if filter.StarredOnly {
+		// Starred articles stay listed after their feed is unsubscribed, so
+		// start from the stars and pick up the subscription title if any.
+		subscribed = `JOIN (
+			SELECT st.article_id, st.user_id, sub.title
+			FROM article_state st
+			JOIN articles sa ON sa.id = st.article_id
+			LEFT JOIN subscriptions sub ON sub.feed_id = sa.feed_id AND sub.user_id = st.user_id
+			WHERE st.user_id = ? AND st.starred = 1
+		) s ON s.article_id = a.id`
+	}
+	from := `
+		FROM articles a
+		JOIN feeds f ON a.feed_id = f.id
+		` + subscribed
+	args := []interface{}{userID}
+	if match != "" {
+		from = `
+			FROM articles_fts
+			JOIN articles a ON a.id = articles_fts.rowid
+			JOIN feeds f ON a.feed_id = f.id
+			` + subscribed
+		conditions = append([]string{"articles_fts MATCH ?"}, conditions...)
+		args = append(args, match)
+	}
+	args = append(args, filterArgs...)
+	if len(conditions) > 0 {
+		from += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	return from, args
+}
+
+// searchFromParams reads a search from request parameters: the words and
+// filters typed in the box as q, plus the filters picked in the search
+// form.
+func searchFromParams(params url.Values) (string, ArticleFilter) {
+	text, filter := parseSearchQuery(params.Get("q"))
+	for _, key := range []string{"feed", "category", "sentiment", "after", "before", "has"} {
+		applySearchFilter(&filter, key, params.Get(key))
+	}
+	return text, filter
+}
+
+// parseSearchQuery separates the filters typed in the search box, such as
+// feed:bbc or after:2024-01-31, from the words to search for. Filters with
+// an unknown key or an invalid value are searched for as words.
+func parseSearchQuery(query string) (string, ArticleFilter) {
+	var filter ArticleFilter
+	var words []string
+	for i := 0; i < len(query); {
+		if isSearchSpace(query[i]) {
+			i++
+			continue
+		}
+		// A token runs to the next space outside quotes, so that
+		// feed:"BBC News" and "quoted phrases" stay whole.
+		start, quoted := i, false
+		for ; i < len(query); i++ {
+			if query[i] == '"' {
+				quoted = !quoted
+			} else if !quoted && isSearchSpace(query[i]) {
+				break
+			}
+		}
+		token := query[start:i]
+		if key, value, ok := strings.Cut(token, ":"); ok && applySearchFilter(&filter, strings.ToLower(key), strings.Trim(value, `"`)) {
+			continue
+		}
+		words = append(words, token)
+	}
+	return strings.Join(words, " "), filter
+}
+
+// applySearchFilter sets the filter field for a search filter given in the
+// search box or the search form, reporting whether the key and value were
+// valid.
+func applySearchFilter(filter *ArticleFilter, key, value string) bool {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return false
+	}
+	switch key {
+	case "feed":
+		if _, err := strconv.Atoi(value); err == nil {
+			filter.FeedID, filter.FeedName = value, ""
+		} else {
+			filter.FeedID, filter.FeedName = "", value
+		}
+	case "category":
+		slug := ""
+		for _, c := range currentCategories() {
+			if strings.EqualFold(value, c.Slug) || strings.EqualFold(value, c.Name) {
+				slug = c.Slug
+			}
+		}
+		if slug == "" && strings.EqualFold(value, otherCategory) {
+			slug = otherCategory
+		}
+		if slug == "" {
+			return false
+		}
+		filter.Category = slug
+	case "sentiment":
+		value = strings.ToLower(value)
+		if !containsString(sentimentLabels, value) {
+			return false
+		}
+		filter.Sentiment = value
+	case "after", "before":
+		day, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			return false
+		}
+		if key == "after" {
+			filter.After = day
+		} else {
+			filter.Before = day
+		}
+	case "has":
+		if !strings.EqualFold(value, "image") {
+			return false
+		}
+		filter.HasImage = true
+	default:
+		return false
+	}
+	return true
+}
+
+func highlightSnippet(snippet string) template.HTML {
+	escaped := html.EscapeString(snippet)
+	escaped = strings.ReplaceAll(escaped, searchMarkStart, "<mark>")
+	escaped = strings.ReplaceAll(escaped, searchMarkEnd, "</mark>")
+	return template.HTML(escaped)
+}
+
+// ftsQuery turns a query typed in the search box into an FTS5 expression.
+// Words and "quoted phrases" are matched as phrases so punctuation cannot
+// break the syntax, word* searches for a prefix, AND, OR, NOT and
+// parentheses combine terms and -word excludes a word. Operators and
+// parentheses that would leave the expression invalid are dropped.
+func ftsQuery(query string) string {
+	var parts, excluded []string
+	depth := 0
+	last := func() string {
+		if len(parts) == 0 {
+			return ""
+		}
+		return parts[len(parts)-1]
+	}
+	isOperator := func(p string) bool { return p == "AND" || p == "OR" || p == "NOT" }
+	endsOperand := func() bool { p := last(); return p != "" && p != "(" && !isOperator(p) }
+	addOperand := func(p string) {
+		if endsOperand() {
+			parts = append(parts, "AND")
+		}
+		parts = append(parts, p)
+	}
+
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case isSearchSpace(c):
+			i++
+		case c == '(':
+			addOperand("(")
+			depth++
+			i++
+		case c == ')':
+			i++
+			for isOperator(last()) {
+				parts = parts[:len(parts)-1]
+			}
+			if depth == 0 {
+				continue
+			}
+			depth--
+			if last() == "(" {
+				parts = parts[:len(parts)-1]
+				continue
+			}
+			parts = append(parts, ")")
+		case c == '"':
+			end := strings.IndexByte(query[i+1:], '"')
+			var phrase string
+			if end < 0 {
+				phrase, i = query[i+1:], len(query)
+			} else {
+				phrase, i = query[i+1:i+1+end], i+end+2
+			}
+			prefix := i < len(query) && query[i] == '*'
+			if prefix {
+				i++
+			}
+			if term := ftsPhrase(phrase, prefix); term != "" {
+				addOperand(term)
+			}
+		default:
+			end := strings.IndexAny(query[i:], " \t\n\r()\"")
+			if end < 0 {
+				end = len(query) - i
+			}
+			word := query[i : i+end]
+			i += end
+			if isOperator(word) {
+				if endsOperand() {
+					parts = append(parts, word)
+				} else if word == "NOT" && last() == "AND" {
+					// FTS5's NOT is binary: "a AND NOT b" is "a NOT b".
+					parts[len(parts)-1] = word
+				}
+				continue
+			}
+			negated := strings.HasPrefix(word, "-")
+			word = strings.TrimLeft(word, "-")
+			prefix := strings.HasSuffix(word, "*")
+			term := ftsPhrase(strings.TrimRight(word, "*"), prefix)
+			if term == "" {
+				continue
+			}
+			if negated {
+				excluded = append(excluded, term)
+			} else {
+				addOperand(term)
+			}
+		}
+	}
+	for isOperator(last()) || last() == "(" {
+		if last() == "(" {
+			depth--
+		}
+		parts = parts[:len(parts)-1]
+	}
+	parts = append(parts, strings.Repeat(")", depth))
+
+	expr := strings.TrimSpace(strings.Join(parts, " "))
+	if expr == "" {
+		return ""
+	}
+	for _, term := range excluded {
+		expr = "(" + expr + ") NOT " + term
+	}
+	return expr
+}
+
+func isSearchSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// ftsPhrase quotes text as an FTS5 phrase, or returns "" when it has no
+// searchable characters.
+func ftsPhrase(text string, prefix bool) string {
+	if strings.IndexFunc(text, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) == -1 {
+		return ""
+	}
+	phrase := `"` + strings.ReplaceAll(text, `"`, `""`) + `"`
+	if prefix {
+		phrase += "*"
+	}
+	return phrase
+}
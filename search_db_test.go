@@ -0,0 +1,104 @@
+//go:build sqlite_fts5
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// TestFTSQuerySyntax runs every ftsQuery output against FTS5 to check that
+// no query typed in the search box can produce a syntax error.
+func TestFTSQuerySyntax(t *testing.T) {
+	db := openTestDB(t)
+	for _, tc := range ftsQueryTests {
+		expr := ftsQuery(tc.query)
+		if expr == "" {
+			continue
+		}
+		rows, err := db.Query("SELECT rowid FROM articles_fts WHERE articles_fts MATCH ?", expr)
+		if err != nil {
+			t.Errorf("ftsQuery(%q) = %q: %v", tc.query, expr, err)
+			continue
+		}
+		rows.Close()
+	}
+}
+
+// TestDateFilterWithZonedFeed ingests an item dated in a feed's own time
+// zone just after midnight local time, which is still the previous day in
+// UTC, and checks the date filters place it on the UTC day.
+func TestDateFilterWithZonedFeed(t *testing.T) {
+	db := openTestDB(t)
+	userID := createTestUser(t, db, "dana")
+	feedID, err := addFeed(db, userID, "Zoned", "https://zoned.example/feed", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed, err := getFeedForFetch(db, feedID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	noLimit := time.Duration(0)
+	feed.Retention.IngestMaxAge = &noLimit
+
+	published := time.Date(2024, 3, 1, 1, 30, 0, 0, time.FixedZone("+05", 5*60*60))
+	saveFeedItems(db, feed, []*gofeed.Item{{
+		Title:           "Late-night vote",
+		Link:            "https://zoned.example/vote",
+		Description:     "Parliament voted after midnight.",
+		PublishedParsed: &published,
+	}})
+
+	count := func(query string) int {
+		_, filter := parseSearchQuery(query)
+		_, total, err := listArticles(db, userID, filter, 10, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return total
+	}
+	if n := count("after:2024-03-01"); n != 0 {
+		t.Errorf("after:2024-03-01 matched %d articles, want 0", n)
+	}
+	if n := count("before:2024-03-01"); n != 1 {
+		t.Errorf("before:2024-03-01 matched %d articles, want 1", n)
+	}
+	if n := count("after:2024-02-29 before:2024-03-01"); n != 1 {
+		t.Errorf("after:2024-02-29 before:2024-03-01 matched %d articles, want 1", n)
+	}
+
+	var stored string
+	if err := db.QueryRow("SELECT CAST(published_at AS TEXT) FROM articles").Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(stored, "2024-02-29 20:30:00") {
+		t.Errorf("published_at stored as %q, want UTC", stored)
+	}
+}
+
+func TestPublishedAtToUTC(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := db.Exec("INSERT INTO feeds (name, url) VALUES ('Feed', 'https://news.example/feed')"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`
+		INSERT INTO articles (title, url, feed_id, published_at)
+		VALUES ('Old', 'https://news.example/a', 1, '2024-03-01 01:30:00+05:00')
+	`); err != nil {
+		t.Fatal(err)
+	}
+	if err := publishedAtToUTC(db); err != nil {
+		t.Fatal(err)
+	}
+	var stored string
+	if err := db.QueryRow("SELECT CAST(published_at AS TEXT) FROM articles").Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if want := "2024-02-29 20:30:00+00:00"; stored != want {
+		t.Errorf("published_at = %q, want %q", stored, want)
+	}
+}
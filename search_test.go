@@ -0,0 +1,128 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+var ftsQueryTests = []struct {
+	query string
+	want  string
+}{
+	{"", ""},
+	{"   ", ""},
+	{"election", `"election"`},
+	{"climate change", `"climate" AND "change"`},
+	{`"climate change"`, `"climate change"`},
+	{"elect*", `"elect"*`},
+	{`"new york"*`, `"new york"*`},
+	{"apple OR orange", `"apple" OR "orange"`},
+	{"apple NOT orange", `"apple" NOT "orange"`},
+	{"apple AND NOT orange", `"apple" NOT "orange"`},
+	{"apple -orange", `("apple") NOT "orange"`},
+	{"(a OR b) -c -d", `((( "a" OR "b" )) NOT "c") NOT "d"`},
+	{"(apple OR pear) banana", `( "apple" OR "pear" ) AND "banana"`},
+	{"and or not", `"and" AND "or" AND "not"`},
+	{"c++ rust", `"c++" AND "rust"`},
+	{"don't", `"don't"`},
+	{"naïve café", `"naïve" AND "café"`},
+	{`say "hi`, `"say" AND "hi"`},
+	{`foo"bar`, `"foo" AND "bar"`},
+
+	// Broken syntax is repaired rather than passed to FTS5.
+	{"((apple", `( ( "apple" ))`},
+	{"apple)", `"apple"`},
+	{"()", ""},
+	{"( ) apple", `"apple"`},
+	{"OR apple AND", `"apple"`},
+	{"NOT", ""},
+	{"apple OR OR pear", `"apple" OR "pear"`},
+	{"a (b OR) c", `"a" AND ( "b" ) AND "c"`},
+	{"-orange", ""},
+	{"-", ""},
+	{"***", ""},
+	{`"""`, ""},
+}
+
+func TestFTSQuery(t *testing.T) {
+	for _, tc := range ftsQueryTests {
+		if got := ftsQuery(tc.query); got != tc.want {
+			t.Errorf("ftsQuery(%q) = %q, want %q", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestFTSPhrase(t *testing.T) {
+	tests := []struct {
+		text   string
+		prefix bool
+		want   string
+	}{
+		{"word", false, `"word"`},
+		{"word", true, `"word"*`},
+		{`say "hi"`, false, `"say ""hi"""`},
+		{"--", false, ""},
+		{"", true, ""},
+	}
+	for _, tc := range tests {
+		if got := ftsPhrase(tc.text, tc.prefix); got != tc.want {
+			t.Errorf("ftsPhrase(%q, %v) = %q, want %q", tc.text, tc.prefix, got, tc.want)
+		}
+	}
+}
+
+func TestParseSearchQuery(t *testing.T) {
+	useCategories(t, []Category{{Name: "Technology", Slug: "technology"}})
+	day := func(s string) time.Time {
+		d, _ := time.Parse("2006-01-02", s)
+		return d
+	}
+	tests := []struct {
+		query  string
+		text   string
+		filter ArticleFilter
+	}{
+		{"election results", "election results", ArticleFilter{}},
+		{"feed:12 election", "election", ArticleFilter{FeedID: "12"}},
+		{`feed:"BBC News" election`, "election", ArticleFilter{FeedName: "BBC News"}},
+		{"category:Technology chips", "chips", ArticleFilter{Category: "technology"}},
+		{"category:other", "", ArticleFilter{Category: otherCategory}},
+		{"CATEGORY:technology", "", ArticleFilter{Category: "technology"}},
+		{"sentiment:Negative", "", ArticleFilter{Sentiment: sentimentNegative}},
+		{"after:2024-01-31 before:2024-03-01 rates", "rates", ArticleFilter{After: day("2024-01-31"), Before: day("2024-03-01")}},
+		{"has:image", "", ArticleFilter{HasImage: true}},
+		{`"exact phrase" feed:bbc`, `"exact phrase"`, ArticleFilter{FeedName: "bbc"}},
+
+		// Unknown keys and invalid values are searched as words.
+		{"category:astrology", "category:astrology", ArticleFilter{}},
+		{"after:yesterday", "after:yesterday", ArticleFilter{}},
+		{"sentiment:angry", "sentiment:angry", ArticleFilter{}},
+		{"has:video", "has:video", ArticleFilter{}},
+		{"http://example.com", "http://example.com", ArticleFilter{}},
+		{"feed:", "feed:", ArticleFilter{}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.query, func(t *testing.T) {
+			text, filter := parseSearchQuery(tc.query)
+			if text != tc.text {
+				t.Errorf("text = %q, want %q", text, tc.text)
+			}
+			if filter != tc.filter {
+				t.Errorf("filter = %+v, want %+v", filter, tc.filter)
+			}
+		})
+	}
+}
+
+// TestApplySearchFilterDatesAreUTC checks that date filters are midnight
+// UTC, which is how publication dates are stored.
+func TestApplySearchFilterDatesAreUTC(t *testing.T) {
+	var filter ArticleFilter
+	if !applySearchFilter(&filter, "after", "2024-03-01") {
+		t.Fatal("after:2024-03-01 rejected")
+	}
+	want := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	if !filter.After.Equal(want) || filter.After.Location() != time.UTC {
+		t.Errorf("After = %v, want %v", filter.After, want)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"math"
+	"strings"
+
+	"github.com/jdkato/prose/v2"
+)
+
+const (
+	sentimentPositive = "positive"
+	sentimentNeutral  = "neutral"
+	sentimentNegative = "negative"
+
+	// Normalized scores within this distance of zero are labelled neutral.
+	sentimentNeutralBand = 0.05
+	// Smoothing constant for squashing the raw valence sum into [-1, 1].
+	sentimentAlpha = 15.0
+)
+
+var sentimentLabels = []string{sentimentPositive, sentimentNeutral, sentimentNegative}
+
+// sentimentLexicon gives each word a valence between -4 and 4. The list is
+// tuned for news copy: words like "killed" or "rebound" carry weight, while
+// words that are ambiguous in reporting, such as "fine" or "charge", are
+// left out.
+var sentimentLexicon = map[string]float64{
+	// Positive
+	"accomplish": 2, "accomplished": 2, "achieve": 2, "achievement": 2, "acclaimed": 2,
+	"advance": 1, "advances": 1, "agreement": 1, "amazing": 3, "approve": 1, "approved": 1,
+	"awarded": 2, "award": 2, "beautiful": 3, "benefit": 2, "benefits": 2, "best": 3,
+	"better": 2, "boost": 2, "boosts": 2, "breakthrough": 3, "bright": 1, "celebrate": 3,
+	"celebrates": 3, "celebration": 3, "champion": 2, "champions": 2, "cheer": 2, "clean": 1,
+	"comeback": 2, "confident": 2, "cure": 2, "delight": 3, "delighted": 3, "discover": 1,
+	"effective": 2, "encouraging": 2, "enjoy": 2, "excellent": 3, "excited": 3, "exciting": 3,
+	"fantastic": 4, "favorite": 2, "free": 1, "friendly": 2, "gain": 2, "gains": 2,
+	"generous": 2, "glad": 2, "good": 2, "great": 3, "grow": 1, "growth": 2, "happy": 3,
+	"heal": 2, "healthy": 2, "help": 2, "helps": 2, "hero": 2, "heroes": 2, "hope": 2,
+	"hopeful": 2, "improve": 2, "improved": 2, "improvement": 2, "improves": 2,
+	"innovative": 2, "inspire": 2, "inspiring": 3, "joy": 3, "landmark": 2, "love": 3,
+	"loves": 3, "lucky": 2, "milestone": 2, "optimism": 2, "optimistic": 2, "peace": 2,
+	"peaceful": 2, "popular": 2, "positive": 2, "praise": 3, "praised": 3, "progress": 2,
+	"promising": 2, "prosper": 2, "protect": 1, "rally": 1, "rallies": 1, "rebound": 2,
+	"record": 1, "recover": 2, "recovery": 2, "relief": 2, "rescue": 2, "rescued": 2,
+	"restore": 1, "restored": 1, "reward": 2, "safe": 1, "save": 2, "saved": 2,
+	"secure": 1, "strong": 2, "stronger": 2, "succeed": 3, "success": 2, "successful": 3,
+	"support": 2, "surge": 1, "surges": 1, "thrilled": 3, "thrive": 2, "top": 2,
+	"triumph": 4, "upbeat": 2, "victory": 3, "welcome": 2, "win": 3, "winner": 3,
+	"winning": 3, "wins": 3, "won": 3, "wonderful": 4,
+
+	// Negative
+	"abuse": -3, "accident": -2, "accused": -2, "alarm": -2, "alarming": -2, "anger": -3,
+	"angry": -3, "arrest": -2, "arrested": -3, "assault": -3, "attack": -2, "attacks": -2,
+	"awful": -3, "bad": -3, "ban": -2, "bankrupt": -3, "bankruptcy": -3, "blast": -2,
+	"blame": -2, "bomb": -3, "bombing": -3, "broken": -1, "catastrophe": -3,
+	"catastrophic": -4, "chaos": -2, "collapse": -2, "collapsed": -2, "complain": -2,
+	"concern": -1, "concerns": -1, "conflict": -2, "corrupt": -3, "corruption": -3,
+	"crash": -2, "crashes": -2, "crime": -3, "crisis": -3, "critical": -2, "criticism": -2,
+	"criticized": -2, "cuts": -1, "damage": -3, "damaged": -3, "danger": -2,
+	"dangerous": -2, "dead": -3, "deadly": -3, "death": -2, "deaths": -2, "decline": -1,
+	"declines": -1, "defeat": -2, "deficit": -2, "delay": -1, "delayed": -1, "denied": -2,
+	"destroy": -3, "destroyed": -3, "devastating": -3, "died": -3, "dies": -3,
+	"disaster": -2, "disease": -1, "dispute": -2, "drop": -1, "drops": -1, "emergency": -2,
+	"evacuate": -2, "evacuated": -2, "fail": -2, "failed": -2, "failure": -2, "fake": -3,
+	"fall": -1, "falls": -1, "fatal": -3, "fear": -2, "fears": -2, "fight": -1,
+	"fined": -2, "fire": -2, "flood": -2, "fraud": -4, "guilty": -3,
+	"hack": -1, "hacked": -1, "harm": -2, "hate": -3, "horrible": -3, "hurt": -2, "illegal": -3,
+	"injured": -2, "injuries": -2, "kill": -3, "killed": -3, "killing": -3, "kills": -3,
+	"lawsuit": -2, "layoffs": -2, "lose": -3, "loses": -3, "losing": -3, "loss": -3,
+	"losses": -3, "lost": -3, "murder": -4, "outage": -2, "pain": -2, "panic": -3,
+	"plunge": -2, "plunges": -2, "poor": -2, "problem": -2, "problems": -2, "protest": -2,
+	"recession": -2, "reject": -1, "rejected": -1, "risk": -2, "risks": -2, "sad": -2,
+	"scam": -2, "scandal": -3, "scare": -2, "shooting": -3, "shortage": -2, "slump": -2,
+	"struggle": -2, "struggles": -2, "sue": -2, "sued": -2, "suffer": -2, "suspect": -1,
+	"terror": -3, "terrorist": -3, "threat": -2, "threatens": -2, "toxic": -2,
+	"terrible": -3, "tragedy": -2, "tragic": -2, "trouble": -2, "tumble": -2, "unemployment": -2,
+	"victim": -3, "victims": -3, "violence": -3, "war": -2, "warning": -3, "warns": -2,
+	"weak": -2, "worried": -3, "worse": -3, "worst": -3, "wounded": -2,
+}
+
+// sentimentNegators flip the valence of a sentiment word that follows
+// within a few tokens. prose splits contractions, so "don't" arrives as
+// "do" + "n't".
+var sentimentNegators = map[string]bool{
+	"not": true, "no": true, "never": true, "n't": true, "without": true,
+	"nor": true, "neither": true, "nobody": true, "nothing": true, "none": true,
+	"cannot": true, "hardly": true,
+}
+
+// sentimentBoosters scale the sentiment word directly after them.
+var sentimentBoosters = map[string]float64{
+	"very": 1.3, "extremely": 1.5, "highly": 1.3, "really": 1.2, "deeply": 1.3,
+	"incredibly": 1.4, "hugely": 1.4, "most": 1.2, "so": 1.2, "slightly": 0.7,
+	"somewhat": 0.8, "barely": 0.6,
+}
+
+// scoreSentiment rates text on a scale from -1 (negative) to 1 (positive)
+// using the word lexicon above and returns the score with its label.
+func scoreSentiment(text string) (float64, string) {
+	if strings.TrimSpace(text) == "" {
+		return 0, sentimentNeutral
+	}
+	doc, err := prose.NewDocument(text,
+		prose.WithTagging(false),
+		prose.WithSegmentation(false),
+		prose.WithExtraction(false))
+	if err != nil {
+		log.Printf("Error creating prose document: %v", err)
+		return 0, sentimentNeutral
+	}
+
+	tokens := doc.Tokens()
+	var sum float64
+	for i, tok := range tokens {
+		valence, ok := sentimentLexicon[strings.ToLower(tok.Text)]
+		if !ok {
+			continue
+		}
+		if i > 0 {
+			if boost, ok := sentimentBoosters[strings.ToLower(tokens[i-1].Text)]; ok {
+				valence *= boost
+			}
+		}
+		for j := i - 1; j >= 0 && j >= i-3; j-- {
+			if sentimentNegators[strings.ToLower(tokens[j].Text)] {
+				valence *= -0.75
+				break
+			}
+		}
+		sum += valence
+	}
+
+	score := sum / math.Sqrt(sum*sum+sentimentAlpha)
+	return score, sentimentLabel(score)
+}
+
+func sentimentLabel(score float64) string {
+	switch {
+	case score >= sentimentNeutralBand:
+		return sentimentPositive
+	case score <= -sentimentNeutralBand:
+		return sentimentNegative
+	default:
+		return sentimentNeutral
+	}
+}
+
+// backfillSentiment rescores every stored article, for rows saved before
+// sentiment was computed or after the lexicon changes.
+func backfillSentiment(db *sql.DB) (int, error) {
+	rows, err := db.Query("SELECT id, title, IFNULL(summary, '') FROM articles")
+	if err != nil {
+		return 0, err
+	}
+	type pending struct {
+		id             int
+		title, summary string
+	}
+	var articles []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.title, &p.summary); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		articles = append(articles, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	stmt, err := tx.Prepare("UPDATE articles SET sentiment = ?, sentiment_score = ? WHERE id = ?")
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	defer stmt.Close()
+	for _, a := range articles {
+		score, label := scoreSentiment(articleText(a.title, a.summary))
+		if _, err := stmt.Exec(label, score, a.id); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+	return len(articles), tx.Commit()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestScoreSentiment(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"", sentimentNeutral},
+		{"The committee meets on Tuesday.", sentimentNeutral},
+		{"Team celebrates historic victory", sentimentPositive},
+		{"Rescue crews saved dozens after the storm", sentimentPositive},
+		{"Three killed in deadly crash", sentimentNegative},
+		{"Markets plunge as recession fears grow", sentimentNegative},
+		{"The plan is not good", sentimentNegative},
+		{"Officials say there was no disaster", sentimentPositive},
+		{"This is not a bad result", sentimentPositive},
+	}
+	for _, tc := range tests {
+		t.Run(tc.text, func(t *testing.T) {
+			score, label := scoreSentiment(tc.text)
+			if label != tc.want {
+				t.Errorf("scoreSentiment(%q) = %.3f %s, want %s", tc.text, score, label, tc.want)
+			}
+			if score < -1 || score > 1 {
+				t.Errorf("score %.3f outside [-1, 1]", score)
+			}
+		})
+	}
+}
+
+func TestScoreSentimentBoostersAndNegators(t *testing.T) {
+	plain, _ := scoreSentiment("a good result")
+	boosted, _ := scoreSentiment("a very good result")
+	damped, _ := scoreSentiment("a slightly good result")
+	negated, _ := scoreSentiment("not a good result")
+	if !(boosted > plain && plain > damped && damped > 0) {
+		t.Errorf("boosters: very %.3f, plain %.3f, slightly %.3f", boosted, plain, damped)
+	}
+	if negated >= 0 || math.Abs(negated) >= plain {
+		t.Errorf("negated %.3f, want negative and weaker than %.3f", negated, plain)
+	}
+	// The negator only reaches three tokens back.
+	far, _ := scoreSentiment("not one of the two good results")
+	if far <= 0 {
+		t.Errorf("distant negator flipped the score to %.3f", far)
+	}
+}
+
+func TestSentimentLabel(t *testing.T) {
+	tests := []struct {
+		score float64
+		want  string
+	}{
+		{0, sentimentNeutral},
+		{0.049, sentimentNeutral},
+		{-0.049, sentimentNeutral},
+		{sentimentNeutralBand, sentimentPositive},
+		{-sentimentNeutralBand, sentimentNegative},
+		{0.9, sentimentPositive},
+		{-0.9, sentimentNegative},
+	}
+	for _, tc := range tests {
+		if got := sentimentLabel(tc.score); got != tc.want {
+			t.Errorf("sentimentLabel(%v) = %s, want %s", tc.score, got, tc.want)
+		}
+	}
+}
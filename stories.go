@@ -0,0 +1,188 @@
+package main
+
+import (
+	"database/sql"
+	"hash/fnv"
+	"math/bits"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+var (
+	// Fingerprints at most this many bits apart are the same story.
+	storyMaxDistance = getEnvInt("STORY_MAX_DISTANCE", 6)
+	// How far apart in publication time copies of a story may be.
+	storyWindow = getEnvDuration("STORY_WINDOW", 48*time.Hour)
+)
+
+// storyMu serializes story assignment so that copies of a story saved by
+// different fetch workers at the same time still land in one cluster.
+var storyMu sync.Mutex
+
+// simHash fingerprints an article so that near-identical copies differ in
+// only a few of the 64 bits. Features are the words and word pairs of the
+// text; headline features count twice since outlets rewrite summaries more
+// often than titles. Text without any words has fingerprint 0.
+func simHash(title, summary string) uint64 {
+	var weights [64]int
+	add := func(feature string, weight int) {
+		h := fnv.New64a()
+		h.Write([]byte(feature))
+		sum := h.Sum64()
+		for bit := range weights {
+			if sum&(1<<bit) != 0 {
+				weights[bit] += weight
+			} else {
+				weights[bit] -= weight
+			}
+		}
+	}
+	features := 0
+	for _, part := range []struct {
+		text   string
+		weight int
+	}{{title, 2}, {plainText(summary), 1}} {
+		words := fingerprintWords(part.text)
+		for i, w := range words {
+			add(w, part.weight)
+			if i > 0 {
+				add(words[i-1]+" "+w, part.weight)
+			}
+		}
+		features += len(words)
+	}
+	if features == 0 {
+		return 0
+	}
+	var fingerprint uint64
+	for bit, w := range weights {
+		if w > 0 {
+			fingerprint |= 1 << bit
+		}
+	}
+	return fingerprint
+}
+
+// hammingDistance counts the bits in which two fingerprints differ.
+func hammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+func fingerprintWords(text string) []string {
+	var words []string
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len([]rune(w)) < 2 || categoryStopWords[w] {
+			continue
+		}
+		words = append(words, w)
+	}
+	return words
+}
+
+// assignStory stores the article's fingerprint and adds it to the story of
+// the closest article published within the story window, or starts a new
+// story named after the article itself.
+func assignStory(db *sql.DB, articleID int, fingerprint uint64, publishedAt time.Time) error {
+	storyMu.Lock()
+	defer storyMu.Unlock()
+
+	storyID := articleID
+	if fingerprint != 0 {
+		rows, err := db.Query(`
+			SELECT IFNULL(story_id, id), fingerprint FROM articles
+			WHERE id != ? AND IFNULL(fingerprint, 0) != 0
+			  AND published_at BETWEEN ? AND ?
+		`, articleID, publishedAt.Add(-storyWindow).UTC(), publishedAt.Add(storyWindow).UTC())
+		if err != nil {
+			return err
+		}
+		best := storyMaxDistance + 1
+		for rows.Next() {
+			var story int
+			var other int64
+			if err := rows.Scan(&story, &other); err != nil {
+				rows.Close()
+				return err
+			}
+			if d := hammingDistance(fingerprint, uint64(other)); d < best {
+				best = d
+				storyID = story
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	_, err := db.Exec("UPDATE articles SET fingerprint = ?, story_id = ? WHERE id = ?",
+		int64(fingerprint), storyID, articleID)
+	return err
+}
+
+// groupStories collapses each story in a newest-first article list into its
+// newest article, with the other copies listed as related.
+func groupStories(articles []Article) []Article {
+	index := make(map[int]int)
+	var stories []Article
+	for _, a := range articles {
+		if a.StoryID != 0 {
+			if i, ok := index[a.StoryID]; ok {
+				stories[i].Related = append(stories[i].Related, a)
+				continue
+			}
+			index[a.StoryID] = len(stories)
+		}
+		stories = append(stories, a)
+	}
+	return stories
+}
+
+// RelatedSources counts the distinct feeds among the related articles.
+func (a Article) RelatedSources() int {
+	feeds := make(map[int]bool)
+	for _, r := range a.Related {
+		feeds[r.FeedID] = true
+	}
+	return len(feeds)
+}
+
+// backfillStories fingerprints every stored article and rebuilds the story
+// clusters from scratch, oldest article first.
+func backfillStories(db *sql.DB) (int, error) {
+	rows, err := db.Query("SELECT id, title, IFNULL(summary, ''), published_at FROM articles ORDER BY published_at, id")
+	if err != nil {
+		return 0, err
+	}
+	type pending struct {
+		id             int
+		title, summary string
+		publishedAt    time.Time
+	}
+	var articles []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.title, &p.summary, &p.publishedAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		articles = append(articles, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if _, err := db.Exec("UPDATE articles SET fingerprint = NULL, story_id = NULL"); err != nil {
+		return 0, err
+	}
+	for _, a := range articles {
+		if err := assignStory(db, a.id, simHash(a.title, a.summary), a.publishedAt); err != nil {
+			return 0, err
+		}
+	}
+	return len(articles), nil
+}
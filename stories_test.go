@@ -0,0 +1,89 @@
+package main
+
+import "testing"
+
+func TestSimHash(t *testing.T) {
+	const (
+		title   = "Central bank raises interest rates to curb inflation"
+		summary = "<p>The central bank raised its benchmark interest rate by half a point on Wednesday, the third increase this year, as policymakers try to bring inflation back to target.</p>"
+	)
+	base := simHash(title, summary)
+	tests := []struct {
+		name    string
+		title   string
+		summary string
+		same    bool // within storyMaxDistance of base
+	}{
+		{"identical", title, summary, true},
+		{"case and punctuation", "CENTRAL BANK RAISES INTEREST RATES TO CURB INFLATION!", summary, true},
+		{"markup ignored", title, "The central bank raised its benchmark interest rate by half a point on Wednesday, the third increase this year, as policymakers try to bring inflation back to target.", true},
+		{"wire copy with a changed word", title, "<p>The central bank raised its benchmark interest rate by half a point on Thursday, the third increase this year, as policymakers try to bring inflation back to target.</p>", true},
+		{"different story", "Local team wins championship after dramatic overtime", "<p>Fans poured into the streets after the home side won the title in overtime, ending a twenty year wait.</p>", false},
+		{"same topic, different story", "Central bank holds rates steady amid slowing growth", "<p>Policymakers left borrowing costs unchanged, citing weaker hiring and a cooling housing market.</p>", false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d := hammingDistance(base, simHash(tc.title, tc.summary))
+			if same := d <= storyMaxDistance; same != tc.same {
+				t.Errorf("distance %d, same story %v, want %v", d, same, tc.same)
+			}
+		})
+	}
+}
+
+func TestSimHashWithoutWords(t *testing.T) {
+	for _, tc := range []struct{ title, summary string }{
+		{"", ""},
+		{"!!", "<p> - </p>"},
+		{"a", "<img src=x>"},
+	} {
+		if got := simHash(tc.title, tc.summary); got != 0 {
+			t.Errorf("simHash(%q, %q) = %x, want 0", tc.title, tc.summary, got)
+		}
+	}
+}
+
+func TestHammingDistance(t *testing.T) {
+	tests := []struct {
+		a, b uint64
+		want int
+	}{
+		{0, 0, 0},
+		{0xff, 0xff, 0},
+		{0, 1, 1},
+		{0b1010, 0b0101, 4},
+		{0, ^uint64(0), 64},
+		{1 << 63, 1, 2},
+	}
+	for _, tc := range tests {
+		if got := hammingDistance(tc.a, tc.b); got != tc.want {
+			t.Errorf("hammingDistance(%x, %x) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+		if got := hammingDistance(tc.b, tc.a); got != tc.want {
+			t.Errorf("hammingDistance(%x, %x) = %d, not symmetric", tc.b, tc.a, got)
+		}
+	}
+}
+
+func TestGroupStories(t *testing.T) {
+	articles := []Article{
+		{ID: 5, StoryID: 1},
+		{ID: 4},
+		{ID: 3, StoryID: 1},
+		{ID: 2, StoryID: 2},
+		{ID: 1, StoryID: 1},
+	}
+	got := groupStories(articles)
+	wantIDs := []int{5, 4, 2}
+	if len(got) != len(wantIDs) {
+		t.Fatalf("got %d stories, want %d", len(got), len(wantIDs))
+	}
+	for i, id := range wantIDs {
+		if got[i].ID != id {
+			t.Errorf("story %d is article %d, want %d", i, got[i].ID, id)
+		}
+	}
+	if len(got[0].Related) != 2 || got[0].Related[0].ID != 3 || got[0].Related[1].ID != 1 {
+		t.Errorf("related of article 5 = %+v, want articles 3 and 1", got[0].Related)
+	}
+}
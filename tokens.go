@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Token scopes, each allowing everything the previous one does.
+const (
+	scopeRead  = "read"  // read feeds, articles and searches
+	scopeFeeds = "feeds" // also subscribe, edit feeds and mark articles
+	scopeAdmin = "admin" // also the admin endpoints, for admins only
+)
+
+var apiScopes = []string{scopeRead, scopeFeeds, scopeAdmin}
+
+// APIToken lets a user's scripts call the JSON API. The token itself is
+// shown once when it is created; only its hash is stored.
+type APIToken struct {
+	ID         int
+	Name       string
+	Scope      string
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+}
+
+// Allows reports whether the token's scope covers the given scope.
+func (t APIToken) Allows(scope string) bool {
+	return slices.Index(apiScopes, t.Scope) >= slices.Index(apiScopes, scope)
+}
+
+// createAPIToken stores a new token for the user and returns the raw token,
+// which is the token ID and a random secret. The secret is hashed with
+// bcrypt like a password.
+func createAPIToken(db *sql.DB, userID int, name, scope string) (string, error) {
+	secret, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+	hash, err := hashPassword(secret)
+	if err != nil {
+		return "", err
+	}
+	res, err := db.Exec("INSERT INTO api_tokens (user_id, name, scope, token_hash) VALUES (?, ?, ?, ?)",
+		userID, name, scope, hash)
+	if err != nil {
+		return "", err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(id, 10) + "." + secret, nil
+}
+
+func getAPITokens(db *sql.DB, userID int) ([]APIToken, error) {
+	rows, err := db.Query(`
+		SELECT id, name, scope, created_at, last_used_at
+		FROM api_tokens
+		WHERE user_id = ?
+		ORDER BY created_at DESC, id DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tokens []APIToken
+	for rows.Next() {
+		var t APIToken
+		var lastUsed sql.NullTime
+		if err := rows.Scan(&t.ID, &t.Name, &t.Scope, &t.CreatedAt, &lastUsed); err != nil {
+			return nil, err
+		}
+		t.LastUsedAt = lastUsed.Time
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+func deleteAPIToken(db *sql.DB, userID, id int) error {
+	res, err := db.Exec("DELETE FROM api_tokens WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// verifiedTokenTTL is how long a verified token secret is trusted without
+// checking its bcrypt hash again.
+const verifiedTokenTTL = time.Minute
+
+// verifiedTokens remembers recently verified tokens by ID and SHA-256 of
+// the secret, so that scripts making many calls pay for bcrypt once a
+// minute. The token row is still read on every call, so a deleted token
+// stops working at once.
+var verifiedTokens = struct {
+	sync.Mutex
+	m map[string]time.Time
+}{m: make(map[string]time.Time)}
+
+func verifiedTokenKey(id, secret string) string {
+	return id + "." + hashToken(secret)
+}
+
+func tokenRecentlyVerified(key string, now time.Time) bool {
+	verifiedTokens.Lock()
+	defer verifiedTokens.Unlock()
+	expires, ok := verifiedTokens.m[key]
+	return ok && now.Before(expires)
+}
+
+func rememberVerifiedToken(key string, now time.Time) {
+	verifiedTokens.Lock()
+	defer verifiedTokens.Unlock()
+	for k, expires := range verifiedTokens.m {
+		if !now.Before(expires) {
+			delete(verifiedTokens.m, k)
+		}
+	}
+	verifiedTokens.m[key] = now.Add(verifiedTokenTTL)
+}
+
+// authenticateAPIToken returns the token and the user owning it, or
+// sql.ErrNoRows when the token is not an ID and secret, is unknown, or its
+// secret does not match.
+func authenticateAPIToken(db *sql.DB, token string) (User, APIToken, error) {
+	id, secret, ok := strings.Cut(token, ".")
+	if !ok || id == "" || secret == "" {
+		return User{}, APIToken{}, sql.ErrNoRows
+	}
+	var u User
+	var t APIToken
+	var hash string
+	err := db.QueryRow(`
+		SELECT u.id, u.username, u.created_at, IFNULL(u.is_admin, 0), t.id, t.name, t.scope, t.token_hash
+		FROM api_tokens t
+		JOIN users u ON t.user_id = u.id
+		WHERE t.id = ?
+	`, id).Scan(&u.ID, &u.Username, &u.CreatedAt, &u.IsAdmin, &t.ID, &t.Name, &t.Scope, &hash)
+	if err != nil {
+		return User{}, APIToken{}, err
+	}
+	now := time.Now()
+	key := verifiedTokenKey(strconv.Itoa(t.ID), secret)
+	if tokenRecentlyVerified(key, now) {
+		return u, t, nil
+	}
+	if !checkPasswordHash(secret, hash) {
+		return User{}, APIToken{}, sql.ErrNoRows
+	}
+	rememberVerifiedToken(key, now)
+	return u, t, nil
+}
+
+// touchAPIToken records that the token was used, at most once a minute.
+func touchAPIToken(db *sql.DB, id int, now time.Time) error {
+	_, err := db.Exec(`
+		UPDATE api_tokens SET last_used_at = ?
+		WHERE id = ? AND (last_used_at IS NULL OR last_used_at < ?)
+	`, now.UTC(), id, now.Add(-time.Minute).UTC())
+	return err
+}
+
+// currentToken returns the API token attached to the request by
+// requireToken.
+func currentToken(r *http.Request) APIToken {
+	token, _ := r.Context().Value(tokenContextKey).(APIToken)
+	return token
+}
+
+// requireToken is requireLogin for the JSON API: the user is identified by
+// an "Authorization: Bearer <token>" header instead of the session cookie,
+// and the token must have at least the given scope.
+func requireToken(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		raw, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		raw = strings.TrimSpace(raw)
+		if !ok || raw == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="suprnews"`)
+			writeAPIError(w, http.StatusUnauthorized, "an API token is required")
+			return
+		}
+		user, token, err := authenticateAPIToken(db, raw)
+		if err != nil {
+			if err != sql.ErrNoRows {
+				log.Printf("Error looking up API token: %v", err)
+				writeAPIError(w, http.StatusInternalServerError, "failed to check the API token")
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="suprnews", error="invalid_token"`)
+			writeAPIError(w, http.StatusUnauthorized, "invalid API token")
+			return
+		}
+		if err := touchAPIToken(db, token.ID, time.Now()); err != nil {
+			log.Printf("Error recording API token use: %v", err)
+		}
+		ctx := context.WithValue(r.Context(), userContextKey, user)
+		ctx = context.WithValue(ctx, tokenContextKey, token)
+		r = r.WithContext(ctx)
+		if !requireScope(w, r, scope) {
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requireScope answers 403 and returns false unless the request's token
+// has the scope. Admin tokens stop working once their user is no longer
+// an admin.
+func requireScope(w http.ResponseWriter, r *http.Request, scope string) bool {
+	token := currentToken(r)
+	if !token.Allows(scope) || (scope == scopeAdmin && !currentUser(r).IsAdmin) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="suprnews", error="insufficient_scope", scope="`+scope+`"`)
+		writeAPIError(w, http.StatusForbidden, "this API token lacks the "+scope+" scope")
+		return false
+	}
+	return true
+}
+
+func loadTokensPage(r *http.Request) (PageData, error) {
+	tokens, err := getAPITokens(db, currentUser(r).ID)
+	if err != nil {
+		return PageData{}, err
+	}
+	return PageData{Active: "tokens", APITokens: tokens}, nil
+}
+
+func tokensHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := loadTokensPage(r)
+	if err != nil {
+		http.Error(w, "Failed to load API tokens: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	renderTemplate(w, r, "tokens.html", data)
+}
+
+// createTokenHandler creates a named token with the chosen scope and shows
+// it once.
+func createTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		http.Error(w, "A name is required", http.StatusBadRequest)
+		return
+	}
+	user := currentUser(r)
+	scope := r.FormValue("scope")
+	if !containsString(apiScopes, scope) || (scope == scopeAdmin && !user.IsAdmin) {
+		http.Error(w, "Invalid scope", http.StatusBadRequest)
+		return
+	}
+	token, err := createAPIToken(db, user.ID, name, scope)
+	if err != nil {
+		http.Error(w, "Failed to create API token: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("API token %q with scope %s created for %s", name, scope, user.Username)
+	data, err := loadTokensPage(r)
+	if err != nil {
+		http.Error(w, "Failed to load API tokens: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data.NewToken = token
+	renderTemplate(w, r, "tokens.html", data)
+}
+
+func deleteTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.Atoi(r.URL.Path[len("/tokens/delete/"):])
+	if err != nil {
+		http.Error(w, "Invalid token ID", http.StatusBadRequest)
+		return
+	}
+	if err := deleteAPIToken(db, currentUser(r).ID, id); err != nil {
+		if err == sql.ErrNoRows {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "Failed to revoke API token: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/tokens", http.StatusSeeOther)
+}
@@ -0,0 +1,53 @@
+//go:build sqlite_fts5
+
+package main
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+)
+
+func TestAuthenticateAPIToken(t *testing.T) {
+	db := openTestDB(t)
+	userID := createTestUser(t, db, "scripter")
+	raw, err := createAPIToken(db, userID, "cron", scopeFeeds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, secret, _ := strings.Cut(raw, ".")
+
+	// The second call is answered from the verification cache.
+	for i := 0; i < 2; i++ {
+		user, token, err := authenticateAPIToken(db, raw)
+		if err != nil {
+			t.Fatalf("call %d: %v", i+1, err)
+		}
+		if user.ID != userID || token.Name != "cron" || token.Scope != scopeFeeds {
+			t.Errorf("call %d: got user %d token %+v", i+1, user.ID, token)
+		}
+	}
+
+	for _, bad := range []string{
+		secret,                  // no ID
+		id + ".",                // no secret
+		id + "." + secret + "x", // wrong secret
+		"999." + secret,         // unknown ID
+	} {
+		if _, _, err := authenticateAPIToken(db, bad); err != sql.ErrNoRows {
+			t.Errorf("authenticateAPIToken(%q) error = %v, want sql.ErrNoRows", bad, err)
+		}
+	}
+
+	// A deleted token stops working even while its verification is cached.
+	tokens, err := getAPITokens(db, userID)
+	if err != nil || len(tokens) != 1 {
+		t.Fatalf("getAPITokens = %v, %v", tokens, err)
+	}
+	if err := deleteAPIToken(db, userID, tokens[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := authenticateAPIToken(db, raw); err != sql.ErrNoRows {
+		t.Errorf("deleted token error = %v, want sql.ErrNoRows", err)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestAPITokenAllows(t *testing.T) {
+	tests := []struct {
+		scope, want string
+		allowed     bool
+	}{
+		{scopeRead, scopeRead, true},
+		{scopeRead, scopeFeeds, false},
+		{scopeRead, scopeAdmin, false},
+		{scopeFeeds, scopeRead, true},
+		{scopeFeeds, scopeFeeds, true},
+		{scopeFeeds, scopeAdmin, false},
+		{scopeAdmin, scopeRead, true},
+		{scopeAdmin, scopeFeeds, true},
+		{scopeAdmin, scopeAdmin, true},
+		{"", scopeRead, false},
+		{"bogus", scopeRead, false},
+	}
+	for _, tc := range tests {
+		if got := (APIToken{Scope: tc.scope}).Allows(tc.want); got != tc.allowed {
+			t.Errorf("APIToken{Scope: %q}.Allows(%q) = %v, want %v", tc.scope, tc.want, got, tc.allowed)
+		}
+	}
+}
@@ -1,8 +1,15 @@
 package main
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
@@ -22,6 +29,58 @@ func checkPasswordHash(password, hash string) bool {
 	return err == nil
 }
 
+// Environment configuration helpers
+func getEnvString(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func getEnvInt(name string, fallback int) int {
+	if v := os.Getenv(name); v != "" {
+		n, err := strconv.Atoi(v)
+		if err == nil {
+			return n
+		}
+		log.Printf("Invalid value for %s: %q, using %d", name, v, fallback)
+	}
+	return fallback
+}
+
+func getEnvDuration(name string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(name); v != "" {
+		d, err := time.ParseDuration(v)
+		if err == nil {
+			return d
+		}
+		log.Printf("Invalid value for %s: %q, using %s", name, v, fallback)
+	}
+	return fallback
+}
+
+// Session token helpers
+const sessionDuration = 30 * 24 * time.Hour
+
+func generateToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// isSecureRequest reports whether the request reached us over HTTPS, either
+// directly or through a TLS-terminating proxy.
+func isSecureRequest(r *http.Request) bool {
+	return r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
 // HTTP client helper functions
 func getHTTPClient() *http.Client {
 	return &http.Client{